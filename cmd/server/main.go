@@ -5,7 +5,10 @@ import (
 	"GGChat/internal/api/endpoint"
 	"GGChat/internal/config"
 	database "GGChat/internal/db"
+	"GGChat/internal/mailer"
 	"GGChat/internal/service/db"
+	"GGChat/internal/service/keyset"
+	"GGChat/internal/service/oidc"
 	"GGChat/internal/websocket"
 	"context"
 	"fmt"
@@ -36,15 +39,24 @@ func main() {
 
 	pgService := db.NewDbService(dbPg)
 
-	jwt := api.NewJwt(cfg)
+	keys, err := keyset.New(cfg.Jwt)
+	if err != nil {
+		log.Error(fmt.Errorf("ошибка при загрузке ключей JWT: %s", err))
+		panic(err)
+	}
+	go keys.Run(ctx)
+
+	jwt := api.NewJwt(cfg, keys)
 
-	crutApi := endpoint.NewCrut(pgService, jwt)
 	ws := websocket.NewManager()
 	go ws.Run()
-	chat := endpoint.NewApiChats(pgService, ws)
+	crutApi := endpoint.NewCrut(pgService, jwt, ws, mailer.New(cfg.Mail), oidc.New(cfg.Oidc), cfg)
+	chat := endpoint.NewApiChats(pgService, ws, cfg)
 	aiChat := endpoint.NewAIApiChats(pgService, ws)
+	users := endpoint.NewApiUsers(pgService, ws, cfg)
+	adminApi := endpoint.NewApiAdmin(pgService, ws)
 
-	router := api.NewApi(crutApi, chat, aiChat, cfg)
+	router := api.NewApi(crutApi, chat, aiChat, users, adminApi, pgService, keys, cfg)
 
 	router.Init()
 
@@ -54,6 +66,8 @@ func main() {
 
 	mux.Handle("/api/v1/", router.GetRouter())
 
+	mux.HandleFunc("/.well-known/jwks.json", endpoint.NewApiJwks(keys).GetJwks)
+
 	mux.Handle("/", fileServer)
 
 	port := 8081
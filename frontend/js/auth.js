@@ -0,0 +1,97 @@
+/**
+ * Обертка над fetch: если access-токен истек (401), один раз пробуем
+ * обновить его через /api/v1/users/refresh и повторяем исходный запрос.
+ */
+(function () {
+    const originalFetch = window.fetch.bind(window);
+    let refreshing = null;
+
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : "";
+    }
+
+    // Изменяющие запросы должны дублировать cookie csrf_token в заголовке
+    function withCsrf(input, init) {
+        const method = ((init && init.method) || (input instanceof Request ? input.method : "GET")).toUpperCase();
+        if (["GET", "HEAD", "OPTIONS"].includes(method)) {
+            return init;
+        }
+
+        const headers = new Headers((init && init.headers) || (input instanceof Request ? input.headers : undefined));
+        headers.set("X-CSRF-Token", csrfToken());
+        return { ...init, headers };
+    }
+
+    function refreshToken() {
+        if (!refreshing) {
+            refreshing = originalFetch("/api/v1/users/refresh", withCsrf("/api/v1/users/refresh", {
+                method: "POST",
+                credentials: "include",
+            })).finally(() => {
+                refreshing = null;
+            });
+        }
+        return refreshing;
+    }
+
+    window.fetch = async function (input, init) {
+        init = withCsrf(input, init);
+        const response = await originalFetch(input, init);
+        const url = typeof input === "string" ? input : input.url;
+
+        if (response.status !== 401 || url.includes("/api/v1/users/refresh")) {
+            return response;
+        }
+
+        const refreshed = await refreshToken();
+        if (!refreshed.ok) {
+            return response;
+        }
+
+        return originalFetch(input, init);
+    };
+})();
+
+/**
+ * Cookie с токеном недоступна из JS (HttpOnly), поэтому данные текущего
+ * пользователя берем с сервера.
+ */
+let currentUserPromise = null;
+
+function getCurrentUser() {
+    if (!currentUserPromise) {
+        currentUserPromise = fetch("/api/v1/users/me", { credentials: "include" })
+            .then((response) => {
+                if (!response.ok) {
+                    throw new Error(`HTTP ${response.status}`);
+                }
+                return response.json();
+            })
+            .catch((error) => {
+                currentUserPromise = null;
+                throw error;
+            });
+    }
+    return currentUserPromise;
+}
+
+/**
+ * Открывает WebSocket чата по одноразовому билету из /api/v1/chats/ws_ticket.
+ */
+async function openChatSocket(chatUuid) {
+    const response = await fetch("/api/v1/chats/ws_ticket", {
+        method: "POST",
+        credentials: "include",
+    });
+    if (!response.ok) {
+        throw new Error(`HTTP ${response.status}`);
+    }
+    const { ticket } = await response.json();
+
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+    return new WebSocket(
+        `${scheme}://${window.location.host}/api/v1/chats/ws/${chatUuid}?ticket=${encodeURIComponent(ticket)}`,
+        ["ggchat"],
+    );
+}
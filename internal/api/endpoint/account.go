@@ -0,0 +1,191 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/users"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExportMe отдает zip-архив со всеми данными пользователя: профилем,
+// списком чатов, собственными сообщениями (в зашифрованном виде вместе
+// с ключами), AI-чатами и сгенерированными документами.
+func (a *ApiUsers) ExportMe(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на выгрузку данных пользователя...")
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error export data", http.StatusBadRequest)
+		return
+	}
+
+	export, err := a.repo.ExportUserData(context.Background(), userId)
+	if err != nil {
+		log.Error("Ошибка выгрузки данных пользователя: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ggchat-export-%d-%s.zip\"", userId, time.Now().Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	defer archive.Close()
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"chats.json", export.Chats},
+		{"messages.json", export.Messages},
+		{"ai_chats.json", export.AiChats},
+	}
+
+	for _, file := range files {
+		if err = writeZipJSON(archive, file.name, file.data); err != nil {
+			log.Error("Ошибка записи архива: ", err)
+			return
+		}
+	}
+
+	if avatar, err := a.repo.GetAvatarPath(context.Background(), userId); err == nil && avatar != "" {
+		name := filepath.Base(avatar)
+		if err = copyToZip(archive, "avatar"+filepath.Ext(name), filepath.Join(a.cfg.Storage.AvatarDir, name)); err != nil {
+			log.Warn("Не удалось добавить аватар в архив: ", err)
+		}
+	}
+
+	for _, document := range export.Documents {
+		name := document.Name
+		if name == "" {
+			name = filepath.Base(document.Path)
+		}
+
+		entry := fmt.Sprintf("documents/%d/%s", document.AiChatId, filepath.Base(name))
+		if err = copyToZip(archive, entry, document.Path); err != nil {
+			log.Warn("Не удалось добавить документ в архив: ", err)
+		}
+	}
+
+	log.Info("Пользователь №", userId, " выгрузил свои данные")
+}
+
+func writeZipJSON(archive *zip.Writer, name string, v any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func copyToZip(archive *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// DeleteMe безвозвратно удаляет аккаунт. Требует пароль, а при включенной
+// 2FA — еще и код TOTP или код восстановления.
+func (v *ApiVerifications) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на удаление аккаунта...")
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete account", http.StatusBadRequest)
+		return
+	}
+
+	body := users.DeleteAccountRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	valid, err := v.repo.CheckPassword(ctx, userId, body.Password)
+	if err != nil {
+		log.Error("Ошибка проверки пароля: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	if !valid {
+		http.Error(w, "Invalid password", http.StatusForbidden)
+		return
+	}
+
+	_, _, totpEnabled, err := v.repo.GetTotp(ctx, userId)
+	if err != nil {
+		log.Error("Ошибка получения настроек TOTP: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	if totpEnabled {
+		valid, err = v.checkSecondFactor(ctx, userId, body.Code)
+		if err != nil {
+			log.Error("Ошибка проверки кода: ", err)
+			http.Error(w, "Invalid request in database", http.StatusBadRequest)
+			return
+		}
+
+		if !valid {
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	revoked, avatar, err := v.repo.DeleteUser(ctx, userId)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка удаления аккаунта: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range revoked {
+		v.WebsocketManager.CloseSession(id.String())
+	}
+
+	if avatar != "" {
+		os.Remove(filepath.Join(v.cfg.Storage.AvatarDir, filepath.Base(avatar)))
+	}
+
+	clearTokens(w)
+	w.WriteHeader(http.StatusNoContent)
+
+	log.Info("Пользователь №", userId, " удалил аккаунт")
+}
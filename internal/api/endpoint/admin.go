@@ -0,0 +1,198 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/admin"
+	"GGChat/internal/service/db"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAdminLimit = 50
+	maxAdminLimit     = 200
+
+	statsDays = 30
+)
+
+type ApiAdmin struct {
+	repo             *db.DbService
+	WebsocketManager *MyWS.Manager
+}
+
+func NewApiAdmin(repo *db.DbService, wsManager *MyWS.Manager) *ApiAdmin {
+	return &ApiAdmin{
+		repo:             repo,
+		WebsocketManager: wsManager,
+	}
+}
+
+func (a *ApiAdmin) ListUsers(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, offset := pagination(r, defaultAdminLimit, maxAdminLimit)
+
+	found, total, err := a.repo.AdminListUsers(context.Background(), query, limit, offset)
+	if err != nil {
+		log.Warn("Ошибка получения списка пользователей: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, admin.UsersResponse{
+		Users:  found,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+func (a *ApiAdmin) DisableUser(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, true)
+}
+
+func (a *ApiAdmin) EnableUser(w http.ResponseWriter, r *http.Request) {
+	a.setDisabled(w, r, false)
+}
+
+func (a *ApiAdmin) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	log := logrus.New()
+
+	actorId, targetId, ok := a.target(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := a.repo.SetUserDisabled(context.Background(), targetId, disabled)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка изменения статуса пользователя: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if disabled {
+		a.WebsocketManager.CloseUser(targetId)
+		log.Info("Пользователь №", actorId, " заблокировал аккаунт №", targetId, ", завершено сессий: ", len(revoked))
+	} else {
+		log.Info("Пользователь №", actorId, " разблокировал аккаунт №", targetId)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *ApiAdmin) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	actorId, targetId, ok := a.target(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := a.repo.RevokeAllSessions(context.Background(), targetId)
+	if err != nil {
+		log.Warn("Ошибка отзыва сессий: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.WebsocketManager.CloseUser(targetId)
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", actorId, " завершил все сессии аккаунта №", targetId, ": ", len(revoked))
+}
+
+func (a *ApiAdmin) SetRole(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	actorId, targetId, ok := a.target(w, r)
+	if !ok {
+		return
+	}
+
+	body := admin.RoleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if admin.Rank(body.Role) == 0 {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	err := a.repo.SetUserRole(context.Background(), targetId, body.Role)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка изменения роли: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", actorId, " назначил аккаунту №", targetId, " роль ", body.Role)
+}
+
+func (a *ApiAdmin) GetStats(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	stats, err := a.repo.GetStats(context.Background(), statsDays)
+	if err != nil {
+		log.Warn("Ошибка получения статистики: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// target разбирает id пользователя из URL и проверяет, что роль того,
+// кто выполняет действие, строго выше роли цели. Так модератор не может
+// заблокировать администратора, а администратор — самого себя.
+func (a *ApiAdmin) target(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	actorId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Error get user", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	actorRole, _ := r.Context().Value("role").(string)
+
+	targetId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || targetId == actorId {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	targetRole, err := a.repo.GetUserRole(context.Background(), targetId)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return 0, 0, false
+	}
+	if err != nil {
+		logrus.Warn("Ошибка получения роли: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	if admin.Rank(actorRole) <= admin.Rank(targetRole) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, 0, false
+	}
+
+	return actorId, targetId, true
+}
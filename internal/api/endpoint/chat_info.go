@@ -0,0 +1,199 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/chats"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const maxChatDescriptionLength = 1000
+
+func (a *ApiChats) UpdateChat(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error update chat", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.UpdateChatRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if utf8.RuneCountInString(name) > maxChatNameLength {
+			http.Error(w, "Chat name too long", http.StatusBadRequest)
+			return
+		}
+		body.Name = &name
+	}
+
+	if body.Description != nil && utf8.RuneCountInString(*body.Description) > maxChatDescriptionLength {
+		http.Error(w, "Description too long", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	info, err := a.repo.UpdateChat(ctx, chatId, userId, body)
+	if errors.Is(err, database.ErrChatNameRequired) {
+		http.Error(w, "Chat name required", http.StatusBadRequest)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка обновления чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.notifyChatUpdated(ctx, userId, info)
+
+	writeJSON(w, http.StatusOK, info)
+	log.Info("Пользователь №", userId, " обновил чат ", chatId)
+}
+
+func (a *ApiChats) UploadChatAvatar(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error upload avatar", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := storeAvatar(w, r, a.cfg.Storage, "chat-"+chatId.String())
+	if !ok {
+		return
+	}
+
+	if !a.setChatAvatar(w, chatId, userId, name) {
+		os.Remove(filepath.Join(a.cfg.Storage.AvatarDir, name))
+		return
+	}
+
+	log.Info("Пользователь №", userId, " загрузил аватар чата ", chatId)
+}
+
+func (a *ApiChats) DeleteChatAvatar(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete avatar", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	if a.setChatAvatar(w, chatId, userId, "") {
+		log.Info("Пользователь №", userId, " удалил аватар чата ", chatId)
+	}
+}
+
+// setChatAvatar сохраняет новый аватар в БД, удаляет прежний файл и
+// рассылает chat_updated. Возвращает false, если записан ответ с ошибкой.
+func (a *ApiChats) setChatAvatar(w http.ResponseWriter, chatId uuid.UUID, userId int, name string) bool {
+	ctx := context.Background()
+
+	oldName, info, err := a.repo.SetChatAvatar(ctx, chatId, userId, name)
+	if writeChatError(w, err) {
+		return false
+	}
+	if err != nil {
+		logrus.Warn("Ошибка сохранения аватара чата в БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return false
+	}
+
+	if oldName != "" {
+		os.Remove(filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(oldName)))
+	}
+
+	a.notifyChatUpdated(ctx, userId, info)
+
+	writeJSON(w, http.StatusOK, info)
+	return true
+}
+
+func (a *ApiChats) GetChatAvatar(w http.ResponseWriter, r *http.Request) {
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	name, err := a.repo.GetChatAvatarPath(context.Background(), chatId)
+	if err != nil && !errors.Is(err, database.ErrChatNotFound) {
+		logrus.Warn("Ошибка получения аватара чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if name == "" {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeFile(w, r, filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(name)))
+}
+
+// notifyChatUpdated рассылает участникам новые метаданные чата
+func (a *ApiChats) notifyChatUpdated(ctx context.Context, actorId int, info *chats.ChatInfo) {
+	members, err := a.repo.GetChatMembers(ctx, info.Uuid)
+	if err != nil {
+		logrus.Warn("Ошибка получения участников чата для уведомления: ", err)
+		return
+	}
+
+	recipients := make([]int, 0, len(members))
+	for _, member := range members {
+		recipients = append(recipients, member.UserId)
+	}
+
+	a.WebsocketManager.SendToUsers(recipients, MyWS.Message{
+		Type:      "chat_updated",
+		ChatId:    info.Uuid.String(),
+		UserId:    actorId,
+		Payload:   info,
+		Timestamp: info.UpdatedAt,
+	})
+}
@@ -0,0 +1,325 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/chats"
+	"GGChat/internal/service/token"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const maxInviteHours = 24 * 365
+
+func (a *ApiChats) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error create invite", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.InviteRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.ExpiresInHours < 0 || body.ExpiresInHours > maxInviteHours {
+		http.Error(w, "Invalid expiration", http.StatusBadRequest)
+		return
+	}
+	if body.MaxUses != nil && *body.MaxUses <= 0 {
+		http.Error(w, "Invalid max uses", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInHours > 0 {
+		t := time.Now().Add(time.Duration(body.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	plain, hash, err := token.New()
+	if err != nil {
+		log.Warn("Ошибка генерации приглашения: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	invite, err := a.repo.CreateChatInvite(context.Background(), chatId, userId, hash, body, expiresAt)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка создания приглашения: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, chats.InviteResponse{Invite: *invite, Token: plain})
+	log.Info("Пользователь №", userId, " создал приглашение №", invite.Id, " в чат ", chatId)
+}
+
+func (a *ApiChats) GetInvites(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get invites", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	invites, err := a.repo.GetChatInvites(context.Background(), chatId, userId)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка получения приглашений: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, invites)
+}
+
+func (a *ApiChats) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error revoke invite", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	inviteId, err := strconv.Atoi(chi.URLParam(r, "invite_id"))
+	if err != nil {
+		http.Error(w, "Invalid invite id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.RevokeChatInvite(context.Background(), chatId, userId, inviteId)
+	if errors.Is(err, database.ErrInviteInvalid) {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка отзыва приглашения: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " отозвал приглашение №", inviteId)
+}
+
+// PreviewInvite показывает, в какой чат ведет приглашение, не вступая в него
+func (a *ApiChats) PreviewInvite(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error preview invite", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := a.repo.PreviewChatInvite(context.Background(), token.Hash(chi.URLParam(r, "token")), userId)
+	if errors.Is(err, database.ErrInviteInvalid) {
+		http.Error(w, "Invite not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка проверки приглашения: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, preview)
+}
+
+func (a *ApiChats) JoinByInvite(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error join chat", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	chatId, status, err := a.repo.JoinChatByInvite(ctx, token.Hash(chi.URLParam(r, "token")), userId)
+	if errors.Is(err, database.ErrInviteInvalid) {
+		http.Error(w, "Invite not found or expired", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка вступления в чат по приглашению: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if status == chats.JoinPending {
+		a.notifyManagers(ctx, chatId, userId)
+		writeJSON(w, http.StatusAccepted, chats.JoinResponse{ChatId: chatId, Status: status})
+		log.Info("Пользователь №", userId, " подал заявку на вступление в чат ", chatId)
+		return
+	}
+
+	a.notifyMembers(ctx, chatId, "member_joined", userId, []int{userId}, []int{userId})
+
+	writeJSON(w, http.StatusOK, chats.JoinResponse{ChatId: chatId, Status: status})
+	log.Info("Пользователь №", userId, " вступил в чат ", chatId, " по приглашению")
+}
+
+func (a *ApiChats) GetJoinRequests(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get join requests", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := a.repo.GetJoinRequests(context.Background(), chatId, userId)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка получения заявок на вступление: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, requests)
+}
+
+func (a *ApiChats) ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
+	a.decideJoinRequest(w, r, true)
+}
+
+func (a *ApiChats) RejectJoinRequest(w http.ResponseWriter, r *http.Request) {
+	a.decideJoinRequest(w, r, false)
+}
+
+func (a *ApiChats) decideJoinRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error decide join request", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	requesterId, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	err = a.repo.DecideJoinRequest(ctx, chatId, userId, requesterId, approve)
+	if errors.Is(err, database.ErrJoinRequestMissing) {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка обработки заявки на вступление: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if approve {
+		a.notifyMembers(ctx, chatId, "member_joined", userId, []int{requesterId}, []int{requesterId})
+		log.Info("Пользователь №", userId, " одобрил вступление пользователя №", requesterId, " в чат ", chatId)
+	} else {
+		a.WebsocketManager.SendToUsers([]int{requesterId}, MyWS.Message{
+			Type:      "join_rejected",
+			ChatId:    chatId.String(),
+			UserId:    userId,
+			Timestamp: time.Now(),
+		})
+		log.Info("Пользователь №", userId, " отклонил заявку пользователя №", requesterId, " в чат ", chatId)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// notifyManagers сообщает администраторам и владельцам чата о новой заявке на вступление
+func (a *ApiChats) notifyManagers(ctx context.Context, chatId uuid.UUID, requesterId int) {
+	members, err := a.repo.GetChatMembers(ctx, chatId)
+	if err != nil {
+		logrus.Warn("Ошибка получения участников чата для уведомления: ", err)
+		return
+	}
+
+	var managers []int
+	for _, member := range members {
+		if chats.Can(member.Role, chats.PermManageMembers) {
+			managers = append(managers, member.UserId)
+		}
+	}
+
+	a.WebsocketManager.SendToUsers(managers, MyWS.Message{
+		Type:      "join_requested",
+		ChatId:    chatId.String(),
+		UserId:    requesterId,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,534 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/chats"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxChatNameLength = 255
+
+	defaultRoleChangesLimit = 50
+	maxRoleChangesLimit     = 200
+)
+
+// Про сквозное шифрование в группах. Каждое сообщение шифруется отдельным
+// ключом, который отправитель шифрует публичным ключом каждого участника
+// (поле keys). Поэтому:
+//   - новый участник видит только сообщения, отправленные после вступления:
+//     для старых у него нет ни ключа, ни статуса в message_status;
+//   - в событии member_added приходят публичные ключи новых участников,
+//     чтобы клиенты сразу шифровали для них следующие сообщения;
+//   - исключенный участник пропадает из /public_keys, сервер не сохраняет
+//     для него ключи, а его WebSocket-соединение с чатом закрывается.
+//
+// Каналы сквозным шифрованием не покрываются: шифровать ключ сообщения для
+// тысяч подписчиков дорого, а публикуемое в канале и так читают все, кто
+// в него вступил. Сообщения канала хранятся и рассылаются без ключей.
+
+func (a *ApiChats) newGroupChat(w http.ResponseWriter, userId int, body chats.NewChatRequest) {
+	log := logrus.New()
+
+	name := strings.TrimSpace(body.ChatName)
+	if name == "" || utf8.RuneCountInString(name) > maxChatNameLength {
+		http.Error(w, "Invalid chat name", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	memberIds, ok := a.resolveMembers(w, ctx, userId, body.UserNames)
+	if !ok {
+		return
+	}
+	if len(memberIds)+1 > chats.MaxGroupMembers {
+		http.Error(w, "Too many members", http.StatusBadRequest)
+		return
+	}
+
+	chatType := chats.TypeGroup
+	if body.Type == chats.TypeChannel {
+		chatType = chats.TypeChannel
+	}
+
+	chatId, err := a.repo.NewGroupChat(ctx, name, chatType, userId, memberIds)
+	if err != nil {
+		log.Warn("Ошибка создания группового чата: ", err)
+		http.Error(w, "Error creat new chat", http.StatusBadRequest)
+		return
+	}
+
+	a.notifyMembers(ctx, chatId, "chat_created", userId, append([]int{userId}, memberIds...), nil)
+
+	writeJSON(w, http.StatusCreated, chats.Response{
+		ChatName: name,
+		Uuid:     chatId,
+		Status:   true,
+	})
+	log.Info("Пользователь №", userId, " создал чат ", chatId, " типа ", chatType)
+}
+
+func (a *ApiChats) GetMembers(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get members", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	info, err := a.repo.GetChatInfo(ctx, chatId)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка получения чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	members, err := a.repo.GetChatMembers(ctx, chatId)
+	if err != nil {
+		log.Warn("Ошибка получения участников чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	// Подписчики канала видят только тех, кто в нем публикует
+	if info.Type == chats.TypeChannel {
+		idx := slices.IndexFunc(members, func(m chats.Member) bool { return m.UserId == userId })
+		if idx < 0 || !chats.Can(members[idx].Role, chats.PermManageMembers) {
+			members = slices.DeleteFunc(members, func(m chats.Member) bool {
+				return !chats.Can(m.Role, chats.PermManageMembers)
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, members)
+}
+
+func (a *ApiChats) AddMembers(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error add members", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.MembersRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.UserNames) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	memberIds, ok := a.resolveMembers(w, ctx, userId, body.UserNames)
+	if !ok {
+		return
+	}
+
+	// Лимит участников проверяется в той же транзакции, что и добавление:
+	// при переполнении AddChatMembers возвращает ErrChatFull
+	added, err := a.repo.AddChatMembers(ctx, chatId, userId, memberIds)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка добавления участников чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if len(added) > 0 {
+		a.notifyMembers(ctx, chatId, "member_added", userId, added, added)
+		log.Info("Пользователь №", userId, " добавил в чат ", chatId, " пользователей ", added)
+	}
+
+	writeJSON(w, http.StatusOK, chats.MembersEvent{ActorId: userId, UserIds: added})
+}
+
+func (a *ApiChats) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error remove member", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	memberId, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	roleEvents, err := a.repo.RemoveChatMember(ctx, chatId, userId, memberId)
+	if errors.Is(err, database.ErrMemberNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка исключения участника чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.WebsocketManager.CloseChatMember(chatId.String(), memberId)
+
+	// Исключенный пользователь тоже получает событие, чтобы убрать чат из списка
+	a.notifyMembers(ctx, chatId, "member_removed", userId, []int{memberId}, nil, memberId)
+	a.notifyRoles(ctx, chatId, roleEvents)
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " исключил из чата ", chatId, " пользователя №", memberId)
+}
+
+// LeaveChat выводит пользователя из чата любого типа. Остальные участники
+// получают member_removed, а если чат опустел и удален — сам пользователь
+// получает chat_deleted.
+func (a *ApiChats) LeaveChat(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error leave chat", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	deleted, avatarPath, roleEvents, err := a.repo.LeaveChat(ctx, chatId, userId)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка выхода из чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.WebsocketManager.CloseChatMember(chatId.String(), userId)
+
+	if deleted {
+		if avatarPath != "" {
+			os.Remove(filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(avatarPath)))
+		}
+		a.WebsocketManager.SendToUsers([]int{userId}, MyWS.Message{
+			Type:      "chat_deleted",
+			ChatId:    chatId.String(),
+			UserId:    userId,
+			Timestamp: time.Now(),
+		})
+	} else {
+		a.notifyMembers(ctx, chatId, "member_removed", userId, []int{userId}, nil, userId)
+		a.notifyRoles(ctx, chatId, roleEvents)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " вышел из чата ", chatId)
+}
+
+func (a *ApiChats) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error set role", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	memberId, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.RoleRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil || chats.RoleRank(body.Role) == 0 {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	events, err := a.repo.SetChatMemberRole(ctx, chatId, userId, memberId, body.Role)
+	if errors.Is(err, database.ErrMemberNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка смены роли в чате: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.notifyRoles(ctx, chatId, events)
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " назначил пользователю №", memberId, " роль ", body.Role, " в чате ", chatId)
+}
+
+func (a *ApiChats) GetRoleChanges(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get role changes", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := pagination(r, defaultRoleChangesLimit, maxRoleChangesLimit)
+
+	events, err := a.repo.GetChatRoleChanges(context.Background(), chatId, userId, limit)
+	if err != nil {
+		log.Warn("Ошибка получения журнала ролей: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (a *ApiChats) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete message", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	messageId, err := strconv.Atoi(chi.URLParam(r, "message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.DeleteChatMessage(context.Background(), chatId, messageId, userId)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка удаления сообщения: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.WebsocketManager.SendMessage(MyWS.Message{
+		Type:   "message_deleted",
+		Id:     messageId,
+		ChatId: chatId.String(),
+		UserId: userId,
+	}, nil)
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " удалил сообщение №", messageId, " в чате ", chatId)
+}
+
+// notifyRoles рассылает участникам чата события role_changed
+func (a *ApiChats) notifyRoles(ctx context.Context, chatId uuid.UUID, events []chats.RoleEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	members, err := a.repo.GetChatMembers(ctx, chatId)
+	if err != nil {
+		logrus.Warn("Ошибка получения участников чата для уведомления: ", err)
+		return
+	}
+
+	recipients := make([]int, 0, len(members))
+	for _, member := range members {
+		recipients = append(recipients, member.UserId)
+	}
+
+	for _, event := range events {
+		a.WebsocketManager.SendToUsers(recipients, MyWS.Message{
+			Type:      "role_changed",
+			ChatId:    chatId.String(),
+			UserId:    event.ActorId,
+			Payload:   event,
+			Timestamp: event.CreatedAt,
+		})
+	}
+}
+
+// resolveMembers находит пользователей по логинам и проверяет, что никто
+// из них не заблокировал создателя и не заблокирован им. Ошибку пишет в ответ.
+func (a *ApiChats) resolveMembers(w http.ResponseWriter, ctx context.Context, userId int, userNames []string) ([]int, bool) {
+	if len(userNames) > chats.MaxGroupMembers {
+		http.Error(w, "Too many members", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var memberIds []int
+	for _, name := range userNames {
+		memberId, err := a.repo.GetUser(ctx, strings.TrimSpace(name))
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "User not found: "+name, http.StatusNotFound)
+			return nil, false
+		}
+		if err != nil {
+			logrus.Warn("Ошибка поиска пользователя: ", err)
+			http.Error(w, "Error request database", http.StatusBadRequest)
+			return nil, false
+		}
+
+		if memberId == userId || slices.Contains(memberIds, memberId) {
+			continue
+		}
+
+		blocked, err := a.repo.IsBlocked(ctx, userId, memberId)
+		if err != nil {
+			logrus.Warn("Ошибка проверки блокировки: ", err)
+			http.Error(w, "Error request database", http.StatusBadRequest)
+			return nil, false
+		}
+		if blocked {
+			http.Error(w, "User is blocked: "+name, http.StatusForbidden)
+			return nil, false
+		}
+
+		memberIds = append(memberIds, memberId)
+	}
+
+	return memberIds, true
+}
+
+// notifyMembers рассылает системное событие о составе чата всем его текущим
+// участникам и дополнительно пользователям из extra. В канале событие
+// получают только администраторы и сами затронутые пользователи.
+func (a *ApiChats) notifyMembers(ctx context.Context, chatId uuid.UUID, eventType string, actorId int, userIds []int, withKeys []int, extra ...int) {
+	info, err := a.repo.GetChatInfo(ctx, chatId)
+	if err != nil {
+		logrus.Warn("Ошибка получения чата для уведомления: ", err)
+		return
+	}
+
+	members, err := a.repo.GetChatMembers(ctx, chatId)
+	if err != nil {
+		logrus.Warn("Ошибка получения участников чата для уведомления: ", err)
+		return
+	}
+
+	event := chats.MembersEvent{ActorId: actorId, UserIds: userIds}
+	recipients := extra
+	for _, member := range members {
+		if info.Type == chats.TypeChannel && !chats.Can(member.Role, chats.PermManageMembers) && !slices.Contains(userIds, member.UserId) {
+			continue
+		}
+		recipients = append(recipients, member.UserId)
+		if slices.Contains(withKeys, member.UserId) {
+			event.Members = append(event.Members, member)
+		}
+	}
+
+	a.WebsocketManager.SendToUsers(recipients, MyWS.Message{
+		Type:      eventType,
+		ChatId:    chatId.String(),
+		UserId:    actorId,
+		Payload:   event,
+		Timestamp: time.Now(),
+	})
+}
+
+// writeChatError отвечает клиенту на ошибки доступа к чату. Возвращает true,
+// если ответ записан.
+func writeChatError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, database.ErrChatNotFound):
+		http.Error(w, "Chat not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrNotGroupChat):
+		http.Error(w, "Not a group chat", http.StatusBadRequest)
+	case errors.Is(err, database.ErrChatForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, database.ErrChatFull):
+		http.Error(w, "Too many members", http.StatusConflict)
+	case errors.Is(err, database.ErrUserBlocked):
+		http.Error(w, "User is blocked", http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
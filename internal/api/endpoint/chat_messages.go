@@ -0,0 +1,124 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/chats"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+func (a *ApiChats) EditMessage(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error edit message", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	messageId, err := strconv.Atoi(chi.URLParam(r, "message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.EditMessageRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Content) == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = a.editMessage(context.Background(), chatId, messageId, userId, body)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrEditWindowExpired) {
+		http.Error(w, "Edit window expired", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrChannelKeys) {
+		http.Error(w, "Channel messages are not encrypted", http.StatusBadRequest)
+		return
+	}
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка редактирования сообщения: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " отредактировал сообщение №", messageId, " в чате ", chatId)
+}
+
+func (a *ApiChats) GetMessageEdits(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get edits", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	messageId, err := strconv.Atoi(chi.URLParam(r, "message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	edits, err := a.repo.GetMessageEdits(context.Background(), chatId, messageId, userId)
+	if err != nil {
+		log.Warn("Ошибка получения истории правок: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, edits)
+}
+
+// editMessage сохраняет правку и рассылает message_edited открытым
+// соединениям чата. Ключи, как и у нового сообщения, каждый получатель
+// получает только свой.
+func (a *ApiChats) editMessage(ctx context.Context, chatId uuid.UUID, messageId, userId int, body chats.EditMessageRequest) error {
+	editedAt, keys, err := a.repo.EditMessage(ctx, chatId, messageId, userId, body.Content, body.Keys, a.cfg.Chats.EditWindow)
+	if err != nil {
+		return err
+	}
+
+	a.WebsocketManager.SendMessage(MyWS.Message{
+		Id:        messageId,
+		Type:      "message_edited",
+		Content:   body.Content,
+		ChatId:    chatId.String(),
+		UserId:    userId,
+		Timestamp: editedAt,
+	}, keys)
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/chats"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const maxFolderNameLength = 64
+
+func (a *ApiChats) UpdateChatSettings(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error update settings", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.SettingsRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.MuteFor != nil && *body.MuteFor < -1 {
+		http.Error(w, "Invalid mute duration", http.StatusBadRequest)
+		return
+	}
+	if body.FolderId != nil && *body.FolderId < 0 {
+		http.Error(w, "Invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.UpdateChatSettings(context.Background(), chatId, userId, body)
+	if writeSettingsError(w, err) || writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка изменения настроек чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " изменил настройки чата ", chatId)
+}
+
+// ClearChatHistory скрывает историю чата только для текущего пользователя.
+// Другие его устройства получают history_cleared.
+func (a *ApiChats) ClearChatHistory(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error clear history", http.StatusBadRequest)
+		return
+	}
+
+	chatId, err := uuid.Parse(chi.URLParam(r, "chat_id"))
+	if err != nil {
+		http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+		return
+	}
+
+	clearedUpTo, err := a.repo.ClearChatHistory(context.Background(), chatId, userId)
+	if writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка очистки истории чата: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	a.WebsocketManager.SendToUsers([]int{userId}, MyWS.Message{
+		Type:      "history_cleared",
+		ChatId:    chatId.String(),
+		UserId:    userId,
+		MessageId: clearedUpTo,
+		Timestamp: time.Now(),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " очистил историю чата ", chatId)
+}
+
+func (a *ApiChats) ReorderPinnedChats(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error reorder chats", http.StatusBadRequest)
+		return
+	}
+
+	body := chats.PinnedOrderRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := a.repo.ReorderPinnedChats(context.Background(), userId, body.ChatIds)
+	if writeSettingsError(w, err) || writeChatError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка изменения порядка закрепленных чатов: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " изменил порядок закрепленных чатов")
+}
+
+func (a *ApiChats) GetFolders(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get folders", http.StatusBadRequest)
+		return
+	}
+
+	folders, err := a.repo.GetChatFolders(context.Background(), userId)
+	if err != nil {
+		log.Warn("Ошибка получения списка папок: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, folders)
+}
+
+func (a *ApiChats) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error create folder", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := decodeFolderName(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := a.repo.CreateChatFolder(context.Background(), userId, name)
+	if err != nil {
+		log.Warn("Ошибка создания папки: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, folder)
+	log.Info("Пользователь №", userId, " создал папку №", folder.Id)
+}
+
+func (a *ApiChats) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error rename folder", http.StatusBadRequest)
+		return
+	}
+
+	folderId, err := strconv.Atoi(chi.URLParam(r, "folder_id"))
+	if err != nil {
+		http.Error(w, "Invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := decodeFolderName(w, r)
+	if !ok {
+		return
+	}
+
+	err = a.repo.RenameChatFolder(context.Background(), userId, folderId, name)
+	if writeSettingsError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка переименования папки: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " переименовал папку №", folderId)
+}
+
+func (a *ApiChats) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete folder", http.StatusBadRequest)
+		return
+	}
+
+	folderId, err := strconv.Atoi(chi.URLParam(r, "folder_id"))
+	if err != nil {
+		http.Error(w, "Invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.DeleteChatFolder(context.Background(), userId, folderId)
+	if writeSettingsError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка удаления папки: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " удалил папку №", folderId)
+}
+
+func decodeFolderName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body := chats.FolderRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxFolderNameLength {
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+		return "", false
+	}
+
+	return name, true
+}
+
+func writeSettingsError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, database.ErrFolderNotFound):
+		http.Error(w, "Folder not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrPinLimit):
+		http.Error(w, "Too many pinned chats", http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
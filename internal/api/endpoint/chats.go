@@ -1,13 +1,23 @@
 package endpoint
 
 import (
+	MyMDL "GGChat/internal/api/middleware"
+	"GGChat/internal/config"
+	database "GGChat/internal/db"
 	"GGChat/internal/models/chats"
+	"GGChat/internal/models/crut/tokens"
 	"GGChat/internal/service/db"
+	"GGChat/internal/service/token"
 	MyWS "GGChat/internal/websocket"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -16,21 +26,33 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+const wsTicketTTL = 30 * time.Second
 
 type ApiChats struct {
 	repo             *db.DbService
 	WebsocketManager *MyWS.Manager
+	cfg              *config.Config
+	upgrader         websocket.Upgrader
 }
 
-func NewApiChats(repo *db.DbService, wsManager *MyWS.Manager) *ApiChats {
+func NewApiChats(repo *db.DbService, wsManager *MyWS.Manager, cfg *config.Config) *ApiChats {
 	return &ApiChats{
 		repo:             repo,
 		WebsocketManager: wsManager,
+		cfg:              cfg,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				if MyMDL.OriginAllowed(cfg.Security.AllowedOrigins, r) {
+					return true
+				}
+				logrus.WithFields(logrus.Fields{
+					"path":   r.URL.Path,
+					"origin": r.Header.Get("Origin"),
+				}).Warn("Подключение к WebSocket с неразрешенного источника")
+				return false
+			},
+			Subprotocols: []string{MyWS.Protocol},
+		},
 	}
 }
 
@@ -53,48 +75,92 @@ func (a *ApiChats) NewChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(body.UserNames) > 0 || body.Type == chats.TypeChannel {
+		a.newGroupChat(w, userId, body)
+		return
+	}
+
 	ctx := context.Background()
 
 	other_user_id, err := a.repo.GetUser(ctx, body.UserName)
+	if errors.Is(err, database.ErrUserNotFound) {
+		log.Warn("Пользователь не найден: ", body.UserName)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Warn("Пользователь не найден. Ошибка: ", err)
+		log.Warn("Ошибка поиска пользователя: ", err)
 		http.Error(w, "Error creat new chat", http.StatusBadRequest)
 		return
 	}
 
-	confirmation, uuid, err := a.repo.NewChat(ctx, body.ChatName, userId, other_user_id)
+	// Чат с самим собой — это «Избранное»
+	if other_user_id == userId {
+		chatId, err := a.repo.SavedChat(ctx, userId)
+		if err != nil {
+			log.Warn("Ошибка получения чата «Избранное»: ", err)
+			http.Error(w, "Error creat new chat", http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, chats.Response{
+			ChatName: chats.SavedChatName,
+			Uuid:     chatId,
+			Status:   true,
+		})
+		return
+	}
+
+	created, uuid, chatName, err := a.repo.NewChat(ctx, body.ChatName, userId, other_user_id)
+	if errors.Is(err, database.ErrUserBlocked) {
+		http.Error(w, "User is blocked", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Warn("Ошибка создания нового чата: ", err)
 		http.Error(w, "Error creat new chat", http.StatusBadRequest)
 		return
 	}
 
-	if confirmation == true {
-		response := chats.Response{
-			ChatName: body.ChatName,
-			Uuid:     uuid,
-			Status:   true,
-		}
+	// Для существующего чата название берется из базы, а не из запроса
+	response := chats.Response{
+		ChatName: chatName,
+		Uuid:     uuid,
+		Status:   true,
+	}
 
-		w.Header().Set("Content-Type", "application-json")
-		w.WriteHeader(http.StatusCreated)
-		if err = json.NewEncoder(w).Encode(response); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	// Личный чат с этим собеседником уже есть — возвращаем его
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(status)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
+	if created {
 		log.Info("Новый чат создан")
 	} else {
-		log.Warn("Не удалось создать новый чат")
-		http.Error(w, "couldn't create a new chat", http.StatusBadRequest)
-		return
+		log.Info("Личный чат уже существует: ", uuid)
 	}
 }
 
 func (a *ApiChats) DeleteChat(w http.ResponseWriter, r *http.Request) {
 	log := logrus.New()
 	log.Info("Пришел запрос на удаление чата...")
-	uuidStr := chi.URLParam(r, "uuid")
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete chat", http.StatusBadRequest)
+		return
+	}
+
+	uuidStr := chi.URLParam(r, "chat_id")
 	uuid, err := uuid.Parse(uuidStr)
 	if err != nil {
 		log.Warn("Ошибка парсинга uuid: ", err)
@@ -103,13 +169,27 @@ func (a *ApiChats) DeleteChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := context.Background()
-	err = a.repo.DeleteChat(ctx, uuid)
+	avatarPath, memberIds, err := a.repo.DeleteChat(ctx, uuid, userId)
+	if writeChatError(w, err) {
+		return
+	}
 	if err != nil {
 		log.Warn("Ошибка удаления чата: ", err)
 		http.Error(w, "Error request in database", http.StatusBadRequest)
 		return
 	}
 
+	if avatarPath != "" {
+		os.Remove(filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(avatarPath)))
+	}
+
+	a.WebsocketManager.SendToUsers(memberIds, MyWS.Message{
+		Type:      "chat_deleted",
+		ChatId:    uuid.String(),
+		UserId:    userId,
+		Timestamp: time.Now(),
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err = json.NewEncoder(w).Encode(nil); err != nil {
@@ -133,9 +213,19 @@ func (a *ApiChats) GetAllChats(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("Запрос списка чатов от пользователя №", userId, "...")
 
+	filter := chats.ListFilter{Archived: r.URL.Query().Get("archived") == "true"}
+	if folder := r.URL.Query().Get("folder_id"); folder != "" {
+		folderId, err := strconv.Atoi(folder)
+		if err != nil {
+			http.Error(w, "Invalid folder id", http.StatusBadRequest)
+			return
+		}
+		filter.FolderId = &folderId
+	}
+
 	ctx := context.Background()
 
-	response, err := a.repo.GetAllChats(ctx, userId)
+	response, err := a.repo.GetAllChats(ctx, userId, filter)
 	if err != nil {
 		log.Warn("Ошибка в запросе к БД: ", err)
 		http.Error(w, "Error request database", http.StatusBadRequest)
@@ -188,9 +278,51 @@ func (a *ApiChats) GetMessage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// WsTicket выдает одноразовый билет для подключения к /ws/{chat_id}?ticket=...
+// Билет нужен клиентам, которые не могут передать токен при открытии WebSocket.
+func (a *ApiChats) WsTicket(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error create ticket", http.StatusBadRequest)
+		return
+	}
+
+	ticket := tokens.WsTicket{UserId: userId}
+	if sessionId, ok := r.Context().Value("session_id").(uuid.UUID); ok {
+		ticket.SessionId = &sessionId
+	}
+	if tokenId, ok := r.Context().Value("token_id").(int); ok {
+		ticket.TokenId = &tokenId
+	}
+	if scopes, ok := r.Context().Value("scopes").([]string); ok {
+		ticket.Scopes = scopes
+	}
+
+	plain, hash, err := token.New()
+	if err != nil {
+		log.Warn("Ошибка генерации билета: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.repo.CreateWsTicket(context.Background(), hash, ticket, time.Now().Add(wsTicketTTL)); err != nil {
+		log.Warn("Ошибка сохранения билета: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens.WsTicketResponse{
+		Ticket:    plain,
+		ExpiresIn: int(wsTicketTTL.Seconds()),
+	})
+}
+
 func (a *ApiChats) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	logrus.Info("MEOW")
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := a.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logrus.Error("ошибка апгрейда до WebSocket: ", err)
 		return
@@ -205,12 +337,33 @@ func (a *ApiChats) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sessionId, _ := r.Context().Value("session_id").(uuid.UUID)
+	tokenId, _ := r.Context().Value("token_id").(int)
+
 	client := &MyWS.Client{
-		Id:     fmt.Sprintf("%d-%s", userId, chatIdStr),
-		UserId: userId,
-		ChatId: chatIdStr,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		Id:        fmt.Sprintf("%d-%s", userId, chatIdStr),
+		UserId:    userId,
+		ChatId:    chatIdStr,
+		SessionId: sessionId.String(),
+		TokenId:   tokenId,
+		Conn:      conn,
+		Send:      make(chan []byte, 256),
+	}
+
+	profile, err := a.repo.GetProfile(context.Background(), userId)
+	if err != nil {
+		logrus.Warn("Ошибка получения профиля пользователя: ", err)
+	} else {
+		client.DisplayName = profile.DisplayName
+		client.AvatarUrl = profile.AvatarUrl
+	}
+
+	if chatId, err := uuid.Parse(chatIdStr); err == nil {
+		if info, err := a.repo.GetChatInfo(context.Background(), chatId); err != nil {
+			logrus.Warn("Ошибка получения типа чата: ", err)
+		} else {
+			client.ChatType = info.Type
+		}
 	}
 
 	a.WebsocketManager.Register <- client
@@ -236,46 +389,100 @@ func (a *ApiChats) handleClientMessages(client *MyWS.Client) {
 			break
 		}
 
+		chatId, err := uuid.Parse(client.ChatId)
+		if err != nil {
+			logrus.Error("Ошибка парсинга ChatId:", err)
+			continue
+		}
+
+		// Участника могли исключить, пока соединение было открыто
+		member, err := a.repo.IsMember(context.Background(), chatId, client.UserId)
+		if err != nil || !member {
+			logrus.Warn("Сообщение от пользователя №", client.UserId, " не участника чата ", chatId, ": ", err)
+			return
+		}
+
 		switch msg.Type {
 		case "new_message":
-			chatId, err := uuid.Parse(client.ChatId)
-			if err != nil {
-				logrus.Error("Ошибка парсинга ChatId:", err)
+			messageId, status, err := a.repo.NewMessage(context.Background(), chatId, client.UserId, msg.Content, msg.Keys)
+			if errors.Is(err, database.ErrChatForbidden) {
+				logrus.Warn("Пользователь №", client.UserId, " не может писать в канал ", chatId)
+				continue
+			}
+			if errors.Is(err, database.ErrChannelKeys) {
+				logrus.Warn("Пользователь №", client.UserId, " прислал в канал ", chatId, " зашифрованное сообщение")
 				continue
 			}
-
-			messageId, status, err := a.repo.NewMessage(context.Background(), chatId, client.UserId, msg.Content, msg.Keys)
 			if err != nil {
 				logrus.Error("Ошибка сохранения сообщения:", err)
 				client.Conn.Close()
 				break
 			}
 
-			chatMembers, err := a.repo.GetPublicKeysForChat(context.Background(), chatId, client.UserId)
-			if err != nil {
-				logrus.Warn("Ошибка получения публичного ключа: ", err)
-				return
+			baseMessage := MyWS.Message{
+				Id:              messageId,
+				Type:            "new_message",
+				Content:         msg.Content,
+				ChatId:          client.ChatId,
+				UserId:          client.UserId,
+				SenderName:      client.DisplayName,
+				SenderAvatarUrl: client.AvatarUrl,
+				Status:          status,
+				Timestamp:       time.Now(),
 			}
+			// Сообщения канала не шифруются (ключи NewMessage отклонил)
+			// и рассылаются всем подключенным открытым текстом
+			a.WebsocketManager.SendMessage(baseMessage, msg.Keys)
 
-			chatMembers[client.UserId] = ""
+			// Уведомления получают только участники, не отключившие их;
+			// непрочитанные сообщения считаются для всех
+			recipients, err := a.repo.GetNotifyRecipients(context.Background(), chatId, client.UserId)
+			if err != nil {
+				logrus.Warn("Ошибка получения получателей уведомлений: ", err)
+				continue
+			}
+			a.WebsocketManager.SendToUsers(recipients, MyWS.Message{
+				Id:              messageId,
+				Type:            "notification",
+				ChatId:          client.ChatId,
+				UserId:          client.UserId,
+				SenderName:      client.DisplayName,
+				SenderAvatarUrl: client.AvatarUrl,
+				Timestamp:       baseMessage.Timestamp,
+			})
+
+		case "edit_message":
+			if strings.TrimSpace(msg.Content) == "" {
+				continue
+			}
 
-			baseMessage := MyWS.Message{
-				Id:        messageId,
-				Type:      "new_message",
-				Content:   msg.Content,
-				ChatId:    client.ChatId,
-				UserId:    client.UserId,
-				Status:    status,
-				Timestamp: time.Now(),
+			err = a.editMessage(context.Background(), chatId, msg.MessageId, client.UserId, chats.EditMessageRequest{
+				Content: msg.Content,
+				Keys:    msg.Keys,
+			})
+			if err != nil {
+				logrus.Warn("Не удалось отредактировать сообщение №", msg.MessageId, ": ", err)
 			}
-			a.WebsocketManager.SendMessage(baseMessage, msg.Keys)
 
 		case "read_receipt":
-			err := a.repo.UpdateMessageStatus(context.Background(), msg.MessageId, "read")
+			// В канале отметку прочтения ведет каждый подписчик сам,
+			// статус сообщения остальным не рассылается
+			if client.ChatType == chats.TypeChannel {
+				if err := a.repo.MarkChatRead(context.Background(), chatId, client.UserId, msg.MessageId); err != nil {
+					logrus.Error("Ошибка обновления отметки прочтения:", err)
+				}
+				continue
+			}
+
+			// Отметить прочитанным можно только свою копию сообщения этого чата
+			updated, err := a.repo.UpdateMessageStatus(context.Background(), chatId, msg.MessageId, client.UserId, "read")
 			if err != nil {
 				logrus.Error("Ошибка обновления статуса на 'read':", err)
 				continue
 			}
+			if !updated {
+				continue
+			}
 
 			updateMessage := MyWS.Message{
 				Type:   "status_update",
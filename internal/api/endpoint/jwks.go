@@ -0,0 +1,26 @@
+package endpoint
+
+import (
+	"GGChat/internal/service/keyset"
+	"net/http"
+)
+
+type ApiJwks struct {
+	keys *keyset.KeySet
+}
+
+func NewApiJwks(keys *keyset.KeySet) *ApiJwks {
+	return &ApiJwks{keys: keys}
+}
+
+// GetJwks отдает публичные ключи, чтобы другие сервисы могли сами
+// проверять токены GGChat
+func (a *ApiJwks) GetJwks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, a.keys.JWKS())
+}
@@ -0,0 +1,312 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/crut/identities"
+	"GGChat/internal/service/oidc"
+	"GGChat/internal/service/token"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	loginPage = "/autentifications/index.html"
+	chatsPage = "/chats/all_chats/allchat.html"
+
+	// Cookie привязывает незавершенный вход к браузеру, который его начал
+	oidcBindingCookie = "oidc_binding"
+	oidcCookiePath    = "/api/v1/users/oidc"
+)
+
+func (v *ApiVerifications) GetOidcProviders(w http.ResponseWriter, r *http.Request) {
+	response := make([]identities.ProviderInfo, 0, len(v.oidc))
+	for name, provider := range v.oidc {
+		response = append(response, identities.ProviderInfo{
+			Name:        name,
+			DisplayName: provider.DisplayName(),
+			LoginUrl:    "/api/v1/users/oidc/" + url.PathEscape(name) + "/login",
+		})
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Name < response[j].Name })
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// OidcLogin начинает вход через провайдера: сохраняет state, nonce и
+// PKCE code_verifier и перенаправляет пользователя на страницу провайдера
+func (v *ApiVerifications) OidcLogin(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	authUrl, err := v.startOidc(w, chi.URLParam(r, "provider"), nil)
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка начала входа через провайдера: ", err)
+		http.Error(w, "Provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, authUrl, http.StatusFound)
+}
+
+// OidcLink привязывает внешний аккаунт к текущему пользователю
+func (v *ApiVerifications) OidcLink(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error link identity", http.StatusBadRequest)
+		return
+	}
+
+	authUrl, err := v.startOidc(w, chi.URLParam(r, "provider"), &userId)
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка начала привязки внешнего аккаунта: ", err)
+		http.Error(w, "Provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"url": authUrl})
+}
+
+func (v *ApiVerifications) startOidc(w http.ResponseWriter, name string, linkUserId *int) (string, error) {
+	provider, err := v.oidc.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	state, stateHash, err := token.New()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := token.New()
+	if err != nil {
+		return "", err
+	}
+	verifier, _, err := token.New()
+	if err != nil {
+		return "", err
+	}
+	binding, bindingHash, err := token.New()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+
+	authUrl, err := provider.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	loginState := identities.LoginState{
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		BindingHash:  bindingHash,
+		LinkUserId:   linkUserId,
+	}
+	if err = v.repo.CreateOidcState(ctx, stateHash, loginState, time.Now().Add(v.cfg.Oidc.StateTTL)); err != nil {
+		return "", err
+	}
+
+	// Lax: cookie должна прийти при переходе со страницы провайдера на callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		MaxAge:   int(v.cfg.Oidc.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   v.cfg.Jwt.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+		Path:     oidcCookiePath,
+	})
+
+	return authUrl, nil
+}
+
+// OidcCallback завершает вход: проверяет state, обменивает код на id_token
+// и выдает обычные UserToken и RefreshToken
+func (v *ApiVerifications) OidcCallback(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел ответ от OIDC-провайдера...")
+
+	provider, err := v.oidc.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		log.Warn("Провайдер вернул ошибку: ", errCode, " ", query.Get("error_description"))
+		redirectSsoError(w, r, "denied")
+		return
+	}
+
+	// Без cookie из startOidc ответ провайдера мог быть подсунут чужим
+	// браузером: иначе жертва вошла бы в аккаунт атакующего
+	binding, err := r.Cookie(oidcBindingCookie)
+	if err != nil || binding.Value == "" {
+		log.Warn("Ответ провайдера пришел без cookie привязки к браузеру")
+		redirectSsoError(w, r, "expired")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcBindingCookie, Value: "", MaxAge: -1, Path: oidcCookiePath})
+
+	ctx := context.Background()
+
+	state, err := v.repo.ConsumeOidcState(ctx, token.Hash(query.Get("state")), provider.Name(), token.Hash(binding.Value))
+	if errors.Is(err, database.ErrOidcStateInvalid) {
+		redirectSsoError(w, r, "expired")
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка проверки state: ", err)
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, query.Get("code"), state.CodeVerifier)
+	if err != nil {
+		log.Warn("Ошибка обмена кода авторизации: ", err)
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(identity.Nonce), []byte(state.Nonce)) != 1 {
+		log.Warn("nonce в id_token не совпадает с сохраненным")
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	username := identity.PreferredUsername
+	displayName := identity.Name
+	if displayName == "" {
+		displayName = username
+	}
+
+	ext := identities.External{
+		Provider:      provider.Name(),
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      username,
+		DisplayName:   displayName,
+	}
+
+	if state.LinkUserId != nil {
+		// Привязываем только к пользователю, который сейчас вошел в этом браузере
+		sessionUserId, err := v.sessionUser(ctx, r)
+		if err != nil {
+			log.Warn("Привязка внешнего аккаунта без действующей сессии: ", err)
+			redirectSsoError(w, r, "failed")
+			return
+		}
+		if sessionUserId != *state.LinkUserId {
+			log.Warn("Привязку начал пользователь №", *state.LinkUserId, ", а завершает №", sessionUserId)
+			redirectSsoError(w, r, "failed")
+			return
+		}
+
+		err = v.repo.LinkIdentity(ctx, *state.LinkUserId, ext)
+		if errors.Is(err, database.ErrIdentityTaken) {
+			redirectSsoError(w, r, "taken")
+			return
+		}
+		if err != nil {
+			log.Error("Ошибка привязки внешнего аккаунта: ", err)
+			redirectSsoError(w, r, "failed")
+			return
+		}
+
+		log.Info("Пользователь №", *state.LinkUserId, " привязал аккаунт ", provider.Name())
+		http.Redirect(w, r, chatsPage+"?sso_linked="+url.QueryEscape(provider.Name()), http.StatusFound)
+		return
+	}
+
+	userId, err := v.repo.LoginWithIdentity(ctx, ext, provider.AllowSignup(), provider.LinkByEmail())
+	switch {
+	case errors.Is(err, database.ErrIdentityNotLinked):
+		redirectSsoError(w, r, "not_linked")
+		return
+	case errors.Is(err, database.ErrUserDisabled), errors.Is(err, database.ErrUserNotFound):
+		redirectSsoError(w, r, "disabled")
+		return
+	case err != nil:
+		log.Error("Ошибка входа через провайдера: ", err)
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	_, _, totpEnabled, err := v.repo.GetTotp(ctx, userId)
+	if err != nil {
+		log.Error("Ошибка получения настроек TOTP: ", err)
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	// 2FA в GGChat не отключается входом через провайдера
+	if totpEnabled {
+		challenge, err := v.repo.CreateLoginChallenge(ctx, userId, time.Now().Add(challengeTTL))
+		if err != nil {
+			log.Error("Ошибка создания запроса на подтверждение входа: ", err)
+			redirectSsoError(w, r, "failed")
+			return
+		}
+
+		http.Redirect(w, r, loginPage+"?totp_challenge="+challenge.String(), http.StatusFound)
+		return
+	}
+
+	if err = v.startSession(w, r, userId); err != nil {
+		log.Warn("Ошибка создания сессии: ", err)
+		redirectSsoError(w, r, "failed")
+		return
+	}
+
+	log.Info("Пользователь №", userId, " вошел через ", provider.Name())
+	http.Redirect(w, r, chatsPage, http.StatusFound)
+}
+
+// sessionUser возвращает пользователя по cookie UserToken, если его сессия
+// не отозвана
+func (v *ApiVerifications) sessionUser(ctx context.Context, r *http.Request) (int, error) {
+	cookie, err := r.Cookie("UserToken")
+	if err != nil {
+		return -1, err
+	}
+
+	userId, sessionId, err := v.jwt.ParseToken(cookie.Value)
+	if err != nil {
+		return -1, err
+	}
+
+	active, err := v.repo.TouchSession(ctx, sessionId)
+	if err != nil {
+		return -1, err
+	}
+	if !active {
+		return -1, errors.New("сессия отозвана или истекла")
+	}
+
+	return userId, nil
+}
+
+func redirectSsoError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, loginPage+"?sso_error="+code, http.StatusFound)
+}
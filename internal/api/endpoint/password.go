@@ -0,0 +1,207 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	modelV "GGChat/internal/models/crut/verifications"
+	"GGChat/internal/service/token"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minPasswordLength = 8
+
+	// Письма сброса отправляют несколько фоновых обработчиков из очереди
+	// ограниченного размера: поток запросов не порождает горутину на каждый
+	resetWorkers   = 2
+	resetQueueSize = 100
+)
+
+func (v *ApiVerifications) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на смену пароля...")
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error change password", http.StatusBadRequest)
+		return
+	}
+	sessionId, _ := r.Context().Value("session_id").(uuid.UUID)
+
+	body := modelV.ChangePasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.NewPassword) < minPasswordLength {
+		http.Error(w, "Password too short", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	changed, err := v.repo.ChangePassword(ctx, userId, body.OldPassword, body.NewPassword)
+	if err != nil {
+		log.Error("Ошибка смены пароля: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	if !changed {
+		http.Error(w, "Invalid password", http.StatusForbidden)
+		return
+	}
+
+	revoked, err := v.repo.RevokeOtherSessions(ctx, userId, sessionId)
+	if err != nil {
+		log.Error("Ошибка отзыва сессий: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range revoked {
+		v.WebsocketManager.CloseSession(id.String())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " сменил пароль, завершено сессий: ", len(revoked))
+}
+
+// RequestPasswordReset всегда отвечает 202, чтобы по ответу нельзя было
+// определить, существует ли пользователь. С одного IP принимается не больше
+// reset_per_ip запросов за reset_window, лимит на адрес проверяется при отправке.
+func (v *ApiVerifications) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на сброс пароля...")
+
+	body := modelV.PasswordResetRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+
+	requests, err := v.repo.RegisterPasswordResetRequest(context.Background(), scopeResetIp, ip, v.cfg.Mail.ResetWindow)
+	if err != nil {
+		log.Error("Ошибка учета запросов сброса пароля: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+	if requests > v.cfg.Mail.ResetPerIp {
+		log.Warn("Слишком много запросов сброса пароля с ", ip)
+		w.Header().Set("Retry-After", fmt.Sprint(int(v.cfg.Mail.ResetWindow.Seconds())))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	select {
+	case v.resets <- body.Login:
+	default:
+		log.Warn("Очередь писем сброса пароля переполнена, запрос отброшен")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// runPasswordResets отправляет письма сброса из очереди
+func (v *ApiVerifications) runPasswordResets() {
+	for login := range v.resets {
+		v.sendPasswordReset(login)
+	}
+}
+
+func (v *ApiVerifications) sendPasswordReset(login string) {
+	log := logrus.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	userId, email, err := v.repo.GetUserEmail(ctx, login)
+	if err != nil {
+		log.Error("Ошибка поиска пользователя для сброса пароля: ", err)
+		return
+	}
+
+	if userId == -1 || email == "" {
+		log.Info("Сброс пароля: пользователь не найден или не указан email")
+		return
+	}
+
+	// Лимит на адрес: иначе можно завалить письмами чужой ящик
+	requests, err := v.repo.RegisterPasswordResetRequest(ctx, scopeResetEmail, strings.ToLower(email), v.cfg.Mail.ResetWindow)
+	if err != nil {
+		log.Error("Ошибка учета запросов сброса пароля: ", err)
+		return
+	}
+	if requests > v.cfg.Mail.ResetPerEmail {
+		log.Warn("Слишком много запросов сброса пароля для пользователя №", userId)
+		return
+	}
+
+	plain, hash, err := token.New()
+	if err != nil {
+		log.Error("Ошибка генерации токена сброса: ", err)
+		return
+	}
+
+	if err = v.repo.CreatePasswordReset(ctx, userId, hash, time.Now().Add(v.cfg.Mail.ResetTTL)); err != nil {
+		log.Error("Ошибка сохранения токена сброса: ", err)
+		return
+	}
+
+	link := v.cfg.Mail.ResetUrl + "?token=" + url.QueryEscape(plain)
+	body := fmt.Sprintf("Здравствуйте!\n\nДля сброса пароля в GGChat перейдите по ссылке:\n%s\n\nСсылка действует %s и может быть использована один раз.\nЕсли вы не запрашивали сброс пароля, просто проигнорируйте это письмо.\n",
+		link, v.cfg.Mail.ResetTTL)
+
+	if err = v.mailer.Send(ctx, email, "Сброс пароля GGChat", body); err != nil {
+		log.Error("Ошибка отправки письма для сброса пароля: ", err)
+		return
+	}
+
+	log.Info("Письмо для сброса пароля отправлено пользователю №", userId)
+}
+
+func (v *ApiVerifications) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на установку нового пароля...")
+
+	body := modelV.PasswordResetConfirm{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.NewPassword) < minPasswordLength {
+		http.Error(w, "Password too short", http.StatusBadRequest)
+		return
+	}
+
+	userId, revoked, err := v.repo.ResetPassword(context.Background(), token.Hash(body.Token), body.NewPassword)
+	if errors.Is(err, database.ErrResetTokenInvalid) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка сброса пароля: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range revoked {
+		v.WebsocketManager.CloseSession(id.String())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " сбросил пароль")
+}
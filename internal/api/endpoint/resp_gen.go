@@ -385,7 +385,7 @@ var urlTemplateStorage = "C:\\Users\\salam\\quattroProject\\containers\\"
 func collectingTags(docNum int) ([]string, string, error) {
 	docName, exists := docNumbers[docNum]
 	if !exists {
-		return nil, "", fmt.Errorf("document with number %s not found", docNum)
+		return nil, "", fmt.Errorf("document with number %d not found", docNum)
 	}
 
 	fullPath := urlTemplateStorage + docName + ".txt"
@@ -0,0 +1,129 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+func (v *ApiVerifications) GetSessions(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get sessions", http.StatusBadRequest)
+		return
+	}
+	currentSession, _ := r.Context().Value("session_id").(uuid.UUID)
+
+	log.Info("Запрос списка сессий от пользователя №", userId, "...")
+
+	response, err := v.repo.GetSessions(context.Background(), userId)
+	if err != nil {
+		log.Warn("Ошибка в запросе к БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	for i := range response {
+		response[i].Current = response[i].Id == currentSession
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		log.Warn("Ошибка сервера: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (v *ApiVerifications) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete session", http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Warn("Ошибка парсинга uuid: ", err)
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	log.Info("Пользователь №", userId, " завершает сессию ", sessionId)
+
+	err = v.repo.RevokeUserSession(context.Background(), userId, sessionId)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка отзыва сессии: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	v.WebsocketManager.CloseSession(sessionId.String())
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Сессия завершена")
+}
+
+// deviceLabel строит короткое человекочитаемое название устройства по User-Agent
+func deviceLabel(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	var os string
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		os = "iOS"
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os"):
+		os = "macOS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	var browser string
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " на " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Неизвестное устройство"
+	}
+}
@@ -0,0 +1,132 @@
+package endpoint
+
+import (
+	"GGChat/internal/config"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Области, в которых считаются неудачные попытки входа (login_attempts)
+// и запросы сброса пароля (password_reset_requests)
+const (
+	scopeUser       = "user"
+	scopeIp         = "ip"
+	scopeResetEmail = "email"
+	scopeResetIp    = "ip"
+)
+
+// checkLoginBlock возвращает HTTP-статус и время ожидания, если вход для
+// пользователя или IP сейчас запрещен. 423 — аккаунт временно заблокирован,
+// 429 — действует задержка между попытками.
+func (v *ApiVerifications) checkLoginBlock(ctx context.Context, username, ip string) (int, time.Duration, error) {
+	now := time.Now()
+
+	failures, until, err := v.repo.GetLoginAttempts(ctx, scopeUser, loginKey(username))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if until.After(now) {
+		if failures >= v.cfg.Lockout.MaxFailures {
+			return http.StatusLocked, until.Sub(now), nil
+		}
+		return http.StatusTooManyRequests, until.Sub(now), nil
+	}
+
+	_, until, err = v.repo.GetLoginAttempts(ctx, scopeIp, ip)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if until.After(now) {
+		return http.StatusTooManyRequests, until.Sub(now), nil
+	}
+
+	return 0, 0, nil
+}
+
+// registerLoginFailure увеличивает счетчики ошибок и выставляет
+// экспоненциально растущую задержку для следующей попытки.
+func (v *ApiVerifications) registerLoginFailure(ctx context.Context, username, ip string) error {
+	cfg := v.cfg.Lockout
+	now := time.Now()
+
+	failures, err := v.repo.RegisterLoginFailure(ctx, scopeUser, loginKey(username), cfg.Window)
+	if err != nil {
+		return err
+	}
+
+	if err = v.repo.BlockLogin(ctx, scopeUser, loginKey(username), now.Add(loginDelay(cfg, failures))); err != nil {
+		return err
+	}
+
+	ipFailures, err := v.repo.RegisterLoginFailure(ctx, scopeIp, ip, cfg.Window)
+	if err != nil {
+		return err
+	}
+
+	if delay := ipDelay(cfg, ipFailures); delay > 0 {
+		if err = v.repo.BlockLogin(ctx, scopeIp, ip, now.Add(delay)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *ApiVerifications) resetLoginFailures(ctx context.Context, username string) error {
+	return v.repo.ResetLoginAttempts(ctx, scopeUser, loginKey(username))
+}
+
+func writeLoginBlocked(w http.ResponseWriter, status int, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+
+	if status == http.StatusLocked {
+		http.Error(w, "Account temporarily locked", status)
+		return
+	}
+	http.Error(w, "Too many login attempts", status)
+}
+
+// loginDelay возвращает задержку перед следующей попыткой входа под именем
+// пользователя после failures ошибок подряд: 1, 2, 4... базовой задержки,
+// а начиная с MaxFailures — блокировка на LockDuration, тоже удваивающаяся.
+func loginDelay(cfg config.Lockout, failures int) time.Duration {
+	if failures >= cfg.MaxFailures {
+		return backoff(cfg.LockDuration, failures-cfg.MaxFailures, cfg.MaxDelay)
+	}
+	return backoff(cfg.BaseDelay, failures-1, cfg.MaxDelay)
+}
+
+// ipDelay возвращает задержку для IP после failures ошибок или 0, пока
+// ошибок не больше IpMaxFailures.
+func ipDelay(cfg config.Lockout, failures int) time.Duration {
+	if failures <= cfg.IpMaxFailures {
+		return 0
+	}
+	return backoff(cfg.BaseDelay, failures-cfg.IpMaxFailures-1, cfg.MaxDelay)
+}
+
+func backoff(base time.Duration, n int, max time.Duration) time.Duration {
+	if n < 0 {
+		n = 0
+	}
+	if n > 30 {
+		return max
+	}
+
+	delay := base << n
+	if delay > max || delay <= 0 {
+		return max
+	}
+	return delay
+}
+
+func loginKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,126 @@
+package endpoint
+
+import (
+	"GGChat/internal/config"
+	database "GGChat/internal/db"
+	"GGChat/internal/service/db"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testLockout = config.Lockout{
+	MaxFailures:   5,
+	IpMaxFailures: 20,
+	BaseDelay:     time.Second,
+	MaxDelay:      time.Hour,
+	LockDuration:  15 * time.Minute,
+	Window:        24 * time.Hour,
+}
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 15 * time.Minute},
+		{6, 30 * time.Minute},
+		{7, time.Hour},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := loginDelay(testLockout, tt.failures); got != tt.want {
+			t.Errorf("%d ошибок: задержка %s, ожидалась %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestIpDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{20, 0},
+		{21, time.Second},
+		{22, 2 * time.Second},
+		{60, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := ipDelay(testLockout, tt.failures); got != tt.want {
+			t.Errorf("%d ошибок с IP: задержка %s, ожидалась %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// attemptsRepo хранит login_attempts в памяти, остальные методы не нужны
+type attemptsRepo struct {
+	database.PgRepository
+	failures map[string]int
+	blocked  map[string]time.Time
+}
+
+func (r *attemptsRepo) GetLoginAttempts(ctx context.Context, scope, key string) (int, time.Time, error) {
+	return r.failures[scope+":"+key], r.blocked[scope+":"+key], nil
+}
+
+func (r *attemptsRepo) RegisterLoginFailure(ctx context.Context, scope, key string, window time.Duration) (int, error) {
+	r.failures[scope+":"+key]++
+	return r.failures[scope+":"+key], nil
+}
+
+func (r *attemptsRepo) BlockLogin(ctx context.Context, scope, key string, until time.Time) error {
+	r.blocked[scope+":"+key] = until
+	return nil
+}
+
+func (r *attemptsRepo) ResetLoginAttempts(ctx context.Context, scope, key string) error {
+	delete(r.failures, scope+":"+key)
+	delete(r.blocked, scope+":"+key)
+	return nil
+}
+
+func TestLoginLockout(t *testing.T) {
+	repo := &attemptsRepo{failures: map[string]int{}, blocked: map[string]time.Time{}}
+	v := &ApiVerifications{repo: db.NewDbService(repo), cfg: &config.Config{Lockout: testLockout}}
+	ctx := context.Background()
+
+	check := func(name string, wantStatus int, wantDelay time.Duration) {
+		status, retryAfter, err := v.checkLoginBlock(ctx, "Alice", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// retryAfter отсчитывается от текущего момента и чуть меньше задержки
+		if status != wantStatus || retryAfter > wantDelay || retryAfter < wantDelay-time.Second {
+			t.Errorf("%s: статус %d, ожидание %s; ожидалось %d, %s", name, status, retryAfter, wantStatus, wantDelay)
+		}
+	}
+
+	check("без ошибок", 0, 0)
+	for i := 0; i < 4; i++ {
+		if err := v.registerLoginFailure(ctx, " alice ", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("после 4 ошибок", http.StatusTooManyRequests, 8*time.Second)
+
+	v.registerLoginFailure(ctx, "ALICE", "10.0.0.1")
+	check("после 5 ошибок", http.StatusLocked, 15*time.Minute)
+
+	// Успешный вход обнуляет счетчик: следующая ошибка снова дает базовую задержку
+	if err := v.resetLoginFailures(ctx, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	check("после успешного входа", 0, 0)
+
+	v.registerLoginFailure(ctx, "alice", "10.0.0.1")
+	check("первая ошибка после входа", http.StatusTooManyRequests, time.Second)
+}
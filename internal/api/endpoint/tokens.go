@@ -0,0 +1,144 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	"GGChat/internal/models/crut/tokens"
+	"GGChat/internal/service/token"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxTokenNameLength = 64
+	maxTokenDays       = 365
+)
+
+func (a *ApiUsers) GetAccessTokens(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get tokens", http.StatusBadRequest)
+		return
+	}
+
+	response, err := a.repo.GetAccessTokens(context.Background(), userId)
+	if err != nil {
+		log.Warn("Ошибка в запросе к БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *ApiUsers) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error create token", http.StatusBadRequest)
+		return
+	}
+
+	body := tokens.CreateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || utf8.RuneCountInString(body.Name) > maxTokenNameLength {
+		http.Error(w, "Invalid token name", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range body.Scopes {
+		if !slices.Contains(tokens.Scopes, scope) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	slices.Sort(body.Scopes)
+	body.Scopes = slices.Compact(body.Scopes)
+
+	if body.ExpiresInDays < 0 || body.ExpiresInDays > maxTokenDays {
+		http.Error(w, "Invalid expiration", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	secret, _, err := token.New()
+	if err != nil {
+		log.Warn("Ошибка генерации токена: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	plain := tokens.Prefix + secret
+
+	created, err := a.repo.CreateAccessToken(context.Background(), userId, body.Name, body.Scopes, token.Hash(plain), expiresAt)
+	if err != nil {
+		log.Warn("Ошибка создания токена: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, tokens.CreateResponse{AccessToken: *created, Token: plain})
+	log.Info("Пользователь №", userId, " создал токен доступа «", body.Name, "»")
+}
+
+func (a *ApiUsers) DeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error delete token", http.StatusBadRequest)
+		return
+	}
+
+	tokenId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.RevokeAccessToken(context.Background(), userId, tokenId)
+	if errors.Is(err, database.ErrTokenNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка отзыва токена: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	// Соединения, открытые по токену, не должны пережить его отзыв
+	a.WebsocketManager.CloseToken(tokenId)
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " отозвал токен доступа №", tokenId)
+}
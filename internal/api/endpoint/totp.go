@@ -0,0 +1,301 @@
+package endpoint
+
+import (
+	database "GGChat/internal/db"
+	modelV "GGChat/internal/models/crut/verifications"
+	"GGChat/internal/service/token"
+	"GGChat/internal/service/totp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	totpIssuer          = "GGChat"
+	challengeTTL        = 5 * time.Minute
+	challengeAttempts   = 5
+	recoveryCodesNumber = 10
+)
+
+func (v *ApiVerifications) TotpSetup(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error setup totp", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	username, _, enabled, err := v.repo.GetTotp(ctx, userId)
+	if err != nil {
+		log.Warn("Ошибка в запросе к БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Warn("Ошибка генерации секрета: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = v.repo.SetTotpSecret(ctx, userId, secret); err != nil {
+		log.Warn("Ошибка сохранения секрета: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	response := modelV.TotpSetupResponse{
+		Secret:     secret,
+		OtpauthUri: totp.URI(totpIssuer, username, secret),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("Пользователь №", userId, " начал подключение TOTP")
+}
+
+func (v *ApiVerifications) TotpConfirm(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error confirm totp", http.StatusBadRequest)
+		return
+	}
+
+	body := modelV.TotpCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	_, secret, enabled, err := v.repo.GetTotp(ctx, userId)
+	if err != nil {
+		log.Warn("Ошибка в запросе к БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if enabled || secret == "" {
+		http.Error(w, "Two-factor authentication setup not started", http.StatusConflict)
+		return
+	}
+
+	counter, valid := totp.Validate(secret, body.Code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Warn("Ошибка генерации кодов восстановления: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err = v.repo.EnableTotp(ctx, userId, counter, hashes); err != nil {
+		log.Warn("Ошибка включения TOTP: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	response := modelV.RecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("Пользователь №", userId, " включил TOTP")
+}
+
+func (v *ApiVerifications) TotpDisable(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error disable totp", http.StatusBadRequest)
+		return
+	}
+
+	body := modelV.TotpCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	valid, err := v.checkSecondFactor(ctx, userId, body.Code)
+	if err != nil {
+		log.Warn("Ошибка проверки кода: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err = v.repo.DisableTotp(ctx, userId); err != nil {
+		log.Warn("Ошибка отключения TOTP: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " отключил TOTP")
+}
+
+// UsersVerificationsTotp завершает двухэтапный вход: проверяет код TOTP
+// (или код восстановления) для запроса, выданного на шаге с паролем.
+func (v *ApiVerifications) UsersVerificationsTotp(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на подтверждение входа кодом...")
+
+	body := modelV.TotpLoginRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challengeId, err := uuid.Parse(body.Challenge)
+	if err != nil {
+		http.Error(w, "Invalid challenge", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	userId, err := v.repo.CheckLoginChallenge(ctx, challengeId, challengeAttempts)
+	if errors.Is(err, database.ErrChallengeNotFound) {
+		http.Error(w, "Challenge expired", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка проверки запроса на подтверждение: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := v.checkSecondFactor(ctx, userId, body.Code)
+	if err != nil {
+		log.Error("Ошибка проверки кода: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	if !valid {
+		log.Warn("Неверный код TOTP для пользователя №", userId)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err = v.repo.ConsumeLoginChallenge(ctx, challengeId); err != nil {
+		http.Error(w, "Challenge expired", http.StatusUnauthorized)
+		return
+	}
+
+	if err = v.startSession(w, r, userId); err != nil {
+		log.Warn("Ошибка создания сессии: ", err)
+		http.Error(w, "Error created user token", http.StatusBadRequest)
+		return
+	}
+
+	response := modelV.Response{
+		Id:           userId,
+		Confirmation: true,
+	}
+
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("Верификация пройдена.")
+}
+
+// checkSecondFactor принимает либо текущий код TOTP, либо неиспользованный
+// код восстановления. Каждый код принимается только один раз.
+func (v *ApiVerifications) checkSecondFactor(ctx context.Context, userId int, code string) (bool, error) {
+	_, secret, enabled, err := v.repo.GetTotp(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+
+	if !enabled {
+		return false, nil
+	}
+
+	if counter, ok := totp.Validate(secret, code, time.Now()); ok {
+		return v.repo.UseTotpCounter(ctx, userId, counter)
+	}
+
+	return v.repo.UseRecoveryCode(ctx, userId, token.Hash(normalizeRecoveryCode(code)))
+}
+
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesNumber)
+	hashes := make([]string, 0, recoveryCodesNumber)
+
+	for i := 0; i < recoveryCodesNumber; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+
+		var code strings.Builder
+		for j, b := range buf {
+			if j == 5 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+
+		codes = append(codes, code.String())
+		hashes = append(hashes, token.Hash(normalizeRecoveryCode(code.String())))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,398 @@
+package endpoint
+
+import (
+	"GGChat/internal/config"
+	database "GGChat/internal/db"
+	"GGChat/internal/models/users"
+	"GGChat/internal/service/db"
+	MyWS "GGChat/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+var avatarExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+type ApiUsers struct {
+	repo             *db.DbService
+	WebsocketManager *MyWS.Manager
+	cfg              *config.Config
+}
+
+func NewApiUsers(repo *db.DbService, wsManager *MyWS.Manager, cfg *config.Config) *ApiUsers {
+	return &ApiUsers{
+		repo:             repo,
+		WebsocketManager: wsManager,
+		cfg:              cfg,
+	}
+}
+
+func (a *ApiUsers) GetMe(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error get profile", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := a.repo.GetProfile(context.Background(), userId)
+	if err != nil {
+		log.Warn("Ошибка получения профиля: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (a *ApiUsers) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error update profile", http.StatusBadRequest)
+		return
+	}
+
+	body := users.UpdateProfileRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateProfile(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := a.repo.UpdateProfile(context.Background(), userId, body)
+	if errors.Is(err, database.ErrEmailTaken) {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка обновления профиля: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+	log.Info("Пользователь №", userId, " обновил профиль")
+}
+
+func (a *ApiUsers) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := a.repo.GetProfile(context.Background(), id)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка получения профиля: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	// Email и настройки видны только самому пользователю
+	profile.Email = nil
+	profile.Discoverable = nil
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (a *ApiUsers) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error upload avatar", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := storeAvatar(w, r, a.cfg.Storage, strconv.Itoa(userId))
+	if !ok {
+		return
+	}
+	path := filepath.Join(a.cfg.Storage.AvatarDir, name)
+
+	oldName, err := a.repo.SetAvatar(context.Background(), userId, name)
+	if err != nil {
+		os.Remove(path)
+		log.Warn("Ошибка сохранения аватара в БД: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if oldName != "" {
+		os.Remove(filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(oldName)))
+	}
+
+	profile, err := a.repo.GetProfile(context.Background(), userId)
+	if err != nil {
+		log.Warn("Ошибка получения профиля: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+	log.Info("Пользователь №", userId, " загрузил аватар")
+}
+
+func (a *ApiUsers) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	name, err := a.repo.GetAvatarPath(context.Background(), id)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		logrus.Warn("Ошибка получения аватара: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	if name == "" {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeFile(w, r, filepath.Join(a.cfg.Storage.AvatarDir, filepath.Base(name)))
+}
+
+func (a *ApiUsers) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error search users", http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Empty search query", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := pagination(r, defaultSearchLimit, maxSearchLimit)
+
+	// Запрашиваем на одну запись больше, чтобы понять, есть ли следующая страница
+	found, err := a.repo.SearchUsers(context.Background(), userId, query, limit+1, offset)
+	if err != nil {
+		log.Warn("Ошибка поиска пользователей: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	response := users.SearchResponse{
+		Users:  found,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if len(found) > limit {
+		response.Users = found[:limit]
+		response.HasMore = true
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *ApiUsers) BlockUser(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error block user", http.StatusBadRequest)
+		return
+	}
+
+	blockedId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || blockedId == userId {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.BlockUser(context.Background(), userId, blockedId)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Warn("Ошибка блокировки пользователя: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Info("Пользователь №", userId, " заблокировал пользователя №", blockedId)
+}
+
+func (a *ApiUsers) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+
+	userId, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		log.Warn("Ошибка получения ID пользователя из контекста")
+		http.Error(w, "Error unblock user", http.StatusBadRequest)
+		return
+	}
+
+	blockedId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err = a.repo.UnblockUser(context.Background(), userId, blockedId); err != nil {
+		log.Warn("Ошибка разблокировки пользователя: ", err)
+		http.Error(w, "Error request database", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// storeAvatar читает изображение из поля avatar формы, проверяет размер и тип
+// и сохраняет его в каталог аватаров под именем <prefix>-<uuid>.<ext>.
+// Возвращает имя файла; при ошибке сам пишет ответ клиенту.
+func storeAvatar(w http.ResponseWriter, r *http.Request, storage config.Storage, prefix string) (string, bool) {
+	maxSize := storage.MaxAvatarSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1024*1024)
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "Invalid avatar file", http.StatusBadRequest)
+		return "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		http.Error(w, "Invalid avatar file", http.StatusBadRequest)
+		return "", false
+	}
+
+	if int64(len(data)) > maxSize {
+		http.Error(w, "Avatar too large", http.StatusRequestEntityTooLarge)
+		return "", false
+	}
+
+	ext, ok := avatarExtensions[http.DetectContentType(data)]
+	if !ok {
+		http.Error(w, "Unsupported image type", http.StatusUnsupportedMediaType)
+		return "", false
+	}
+
+	if err = os.MkdirAll(storage.AvatarDir, 0o755); err != nil {
+		logrus.Error("Ошибка создания каталога для аватаров: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+
+	name := fmt.Sprintf("%s-%s%s", prefix, uuid.NewString(), ext)
+
+	if err = os.WriteFile(filepath.Join(storage.AvatarDir, name), data, 0o644); err != nil {
+		logrus.Error("Ошибка сохранения аватара: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+
+	return name, true
+}
+
+// pagination читает limit и offset из query-параметров
+func pagination(r *http.Request, defaultLimit, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+func validateProfile(body *users.UpdateProfileRequest) error {
+	if body.DisplayName != nil {
+		name := strings.TrimSpace(*body.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return fmt.Errorf("display name too long")
+		}
+		body.DisplayName = &name
+	}
+
+	if body.Bio != nil && utf8.RuneCountInString(*body.Bio) > maxBioLength {
+		return fmt.Errorf("bio too long")
+	}
+
+	if body.Timezone != nil && *body.Timezone != "" {
+		if _, err := time.LoadLocation(*body.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone")
+		}
+	}
+
+	if body.Email != nil && *body.Email != "" {
+		addr, err := mail.ParseAddress(*body.Email)
+		if err != nil || addr.Address != *body.Email {
+			return fmt.Errorf("invalid email")
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warn("Ошибка сервера: ", err)
+	}
+}
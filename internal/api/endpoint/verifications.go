@@ -1,28 +1,57 @@
 package endpoint
 
 import (
+	MyMDL "GGChat/internal/api/middleware"
+	"GGChat/internal/config"
+	database "GGChat/internal/db"
 	"GGChat/internal/interfaces"
+	"GGChat/internal/mailer"
 	modelV "GGChat/internal/models/crut/verifications"
 	"GGChat/internal/service/db"
+	"GGChat/internal/service/oidc"
+	"GGChat/internal/service/token"
+	MyWS "GGChat/internal/websocket"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/mail"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type ApiVerifications struct {
-	repo *db.DbService
-	jwt  interfaces.JwtInterface
+	repo             *db.DbService
+	jwt              interfaces.JwtInterface
+	WebsocketManager *MyWS.Manager
+	mailer           mailer.Mailer
+	oidc             oidc.Providers
+	cfg              *config.Config
+	// Логины, для которых нужно отправить письмо сброса пароля
+	resets chan string
 }
 
-func NewCrut(repo *db.DbService, jwt interfaces.JwtInterface) *ApiVerifications {
-	return &ApiVerifications{
-		repo: repo,
-		jwt:  jwt,
+func NewCrut(repo *db.DbService, jwt interfaces.JwtInterface, wsManager *MyWS.Manager, mailer mailer.Mailer, providers oidc.Providers, cfg *config.Config) *ApiVerifications {
+	v := &ApiVerifications{
+		repo:             repo,
+		jwt:              jwt,
+		WebsocketManager: wsManager,
+		mailer:           mailer,
+		oidc:             providers,
+		cfg:              cfg,
+		resets:           make(chan string, resetQueueSize),
 	}
+
+	for i := 0; i < resetWorkers; i++ {
+		go v.runPasswordResets()
+	}
+
+	return v
 }
 
 func (v *ApiVerifications) UsersVerifications(w http.ResponseWriter, r *http.Request) {
@@ -37,42 +66,62 @@ func (v *ApiVerifications) UsersVerifications(w http.ResponseWriter, r *http.Req
 	}
 
 	ctx := context.Background()
+	ip := clientIP(r)
 
-	id, confirmation, err := v.repo.UsersVerification(ctx, body.Username, body.Password)
+	status, retryAfter, err := v.checkLoginBlock(ctx, body.Username, ip)
 	if err != nil {
-		log.Error(fmt.Printf("Неправильный запрос в базу данных: %v", err))
+		log.Error("Ошибка проверки блокировки входа: ", err)
 		http.Error(w, "Invalid request in database", http.StatusBadRequest)
 		return
 	}
 
-	token, err := v.jwt.NewToken(id)
+	if status != 0 {
+		log.Warn("Вход временно запрещен для ", body.Username, " с ", ip)
+		writeLoginBlocked(w, status, retryAfter)
+		return
+	}
+
+	id, confirmation, err := v.repo.UsersVerification(ctx, body.Username, body.Password)
 	if err != nil {
-		log.Warn("Ошибка создания токена: ", err)
-		http.Error(w, "Error created user token", http.StatusBadRequest)
+		log.Error(fmt.Printf("Неправильный запрос в базу данных: %v", err))
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
 		return
 	}
 
-	log.Info("Token: ", token)
+	if confirmation != true {
+		log.Warn("Ошибка верификации")
+		if err = v.registerLoginFailure(ctx, body.Username, ip); err != nil {
+			log.Error("Ошибка сохранения неудачной попытки входа: ", err)
+		}
+		http.Error(w, "Error verifications.", http.StatusBadRequest)
+		return
+	}
 
-	cookie := http.Cookie{
-		Name:     "UserToken",
-		Value:    token,
-		Expires:  time.Now().Add(100 * time.Hour),
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		Path:     "/",
+	if err = v.resetLoginFailures(ctx, body.Username); err != nil {
+		log.Error("Ошибка сброса попыток входа: ", err)
 	}
 
-	http.SetCookie(w, &cookie)
+	_, _, totpEnabled, err := v.repo.GetTotp(ctx, id)
+	if err != nil {
+		log.Error("Ошибка получения настроек TOTP: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
 
-	// Отладочный вывод
-	log.Info("Setting cookie: ", cookie)
+	// При включенной 2FA вместо токена выдаем одноразовый запрос на подтверждение кодом
+	if totpEnabled {
+		challenge, err := v.repo.CreateLoginChallenge(ctx, id, time.Now().Add(challengeTTL))
+		if err != nil {
+			log.Error("Ошибка создания запроса на подтверждение входа: ", err)
+			http.Error(w, "Invalid request in database", http.StatusBadRequest)
+			return
+		}
 
-	if confirmation == true {
 		response := modelV.Response{
 			Id:           id,
-			Confirmation: confirmation,
+			Confirmation: false,
+			TotpRequired: true,
+			Challenge:    challenge.String(),
 		}
 
 		w.Header().Set("Content-Type", "application-json")
@@ -82,12 +131,201 @@ func (v *ApiVerifications) UsersVerifications(w http.ResponseWriter, r *http.Req
 			return
 		}
 
-		log.Info("Верификация пройдена.")
-	} else {
-		log.Warn("Ошибка верификации")
-		http.Error(w, "Error verifications.", http.StatusBadRequest)
+		log.Info("Пароль верный, ожидается код TOTP.")
 		return
 	}
+
+	if err = v.startSession(w, r, id); err != nil {
+		log.Warn("Ошибка создания сессии: ", err)
+		http.Error(w, "Error created user token", http.StatusBadRequest)
+		return
+	}
+
+	response := modelV.Response{
+		Id:           id,
+		Confirmation: confirmation,
+	}
+
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("Верификация пройдена.")
+}
+
+// startSession создает новую сессию пользователя и выставляет cookie
+// с access- и refresh-токенами.
+func (v *ApiVerifications) startSession(w http.ResponseWriter, r *http.Request, userId int) error {
+	secret, hash, err := token.New()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	sessionId, err := v.repo.CreateSession(ctx, userId, hash, deviceLabel(r.UserAgent()), r.UserAgent(), clientIP(r), time.Now().Add(v.cfg.Jwt.RefreshTTL))
+	if err != nil {
+		return err
+	}
+
+	return v.setTokens(w, userId, sessionId, secret)
+}
+
+func (v *ApiVerifications) setTokens(w http.ResponseWriter, userId int, sessionId uuid.UUID, secret string) error {
+	accessToken, err := v.jwt.NewToken(userId, sessionId)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "UserToken",
+		Value:    accessToken,
+		Expires:  time.Now().Add(v.cfg.Jwt.AccessTTL),
+		HttpOnly: true,
+		Secure:   v.cfg.Jwt.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "RefreshToken",
+		Value:    sessionId.String() + "." + secret,
+		Expires:  time.Now().Add(v.cfg.Jwt.RefreshTTL),
+		HttpOnly: true,
+		Secure:   v.cfg.Jwt.SecureCookie,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/api/v1/users",
+	})
+
+	return nil
+}
+
+func clearTokens(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "UserToken", Value: "", MaxAge: -1, Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: "RefreshToken", Value: "", MaxAge: -1, Path: "/api/v1/users"})
+}
+
+// parseRefreshToken разбирает значение cookie RefreshToken вида <session_id>.<secret>
+func parseRefreshToken(r *http.Request) (uuid.UUID, string, error) {
+	cookie, err := r.Cookie("RefreshToken")
+	if err != nil {
+		return uuid.UUID{}, "", err
+	}
+
+	sessionStr, secret, ok := strings.Cut(cookie.Value, ".")
+	if !ok || secret == "" {
+		return uuid.UUID{}, "", fmt.Errorf("неверный формат refresh-токена")
+	}
+
+	sessionId, err := uuid.Parse(sessionStr)
+	if err != nil {
+		return uuid.UUID{}, "", fmt.Errorf("неверный формат refresh-токена: %w", err)
+	}
+
+	return sessionId, secret, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (v *ApiVerifications) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на обновление токена...")
+
+	sessionId, secret, err := parseRefreshToken(r)
+	if err != nil {
+		log.Warn("Ошибка чтения refresh-токена: ", err)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	newSecret, newHash, err := token.New()
+	if err != nil {
+		log.Warn("Ошибка генерации refresh-токена: ", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.Background()
+
+	userId, err := v.repo.RotateSession(ctx, sessionId, token.Hash(secret), newHash, time.Now().Add(v.cfg.Jwt.RefreshTTL))
+	if errors.Is(err, database.ErrSessionNotFound) || errors.Is(err, database.ErrRefreshTokenReused) {
+		log.Warn("Отказ в обновлении токена: ", err)
+		clearTokens(w)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Error("Ошибка обновления сессии: ", err)
+		http.Error(w, "Invalid request in database", http.StatusBadRequest)
+		return
+	}
+
+	if err = v.setTokens(w, userId, sessionId, newSecret); err != nil {
+		log.Warn("Ошибка создания токена: ", err)
+		http.Error(w, "Error created user token", http.StatusBadRequest)
+		return
+	}
+
+	response := modelV.Response{
+		Id:           userId,
+		Confirmation: true,
+	}
+
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (v *ApiVerifications) Logout(w http.ResponseWriter, r *http.Request) {
+	log := logrus.New()
+	log.Info("Пришел запрос на выход из аккаунта...")
+
+	sessionId, secret, err := parseRefreshToken(r)
+	if err == nil {
+		err = v.repo.RevokeSession(context.Background(), sessionId, token.Hash(secret))
+		if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+			log.Error("Ошибка отзыва сессии: ", err)
+			http.Error(w, "Invalid request in database", http.StatusBadRequest)
+			return
+		}
+
+		v.WebsocketManager.CloseSession(sessionId.String())
+	}
+
+	clearTokens(w)
+	w.WriteHeader(http.StatusOK)
+
+	log.Info("Пользователь вышел из аккаунта")
+}
+
+// GetCsrfToken отдает CSRF-токен разрешенным фронтендам на другом источнике:
+// cookie csrf_token принадлежит API, и прочитать ее они не могут
+func (v *ApiVerifications) GetCsrfToken(w http.ResponseWriter, r *http.Request) {
+	if !MyMDL.OriginAllowed(v.cfg.Security.AllowedOrigins, r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	csrf, ok := r.Context().Value("csrf_token").(string)
+	if !ok || csrf == "" {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"csrf_token": csrf})
 }
 
 func (v *ApiVerifications) UsersRegistrations(w http.ResponseWriter, r *http.Request) {
@@ -100,9 +338,17 @@ func (v *ApiVerifications) UsersRegistrations(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if body.Email != "" {
+		addr, err := mail.ParseAddress(body.Email)
+		if err != nil || addr.Address != body.Email {
+			http.Error(w, "Invalid email", http.StatusBadRequest)
+			return
+		}
+	}
+
 	ctx := context.Background()
 
-	confirmation, id, err := v.repo.NewUser(ctx, body.Username, body.Password)
+	confirmation, id, err := v.repo.NewUser(ctx, body.Username, body.Password, body.Email)
 	if err != nil {
 		http.Error(w, "Invalid request in database", http.StatusBadRequest)
 		return
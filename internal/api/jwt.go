@@ -3,44 +3,52 @@ package api
 import (
 	"GGChat/internal/config"
 	"GGChat/internal/interfaces"
+	"GGChat/internal/service/keyset"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Jwt struct {
-	cfg *config.Config
+	cfg  *config.Config
+	keys *keyset.KeySet
 }
 
 type CustomClaims struct {
-	UserId int
+	UserId    int
+	SessionId uuid.UUID
 	jwt.RegisteredClaims
 }
 
-func NewJwt(cfg *config.Config) *Jwt {
+func NewJwt(cfg *config.Config, keys *keyset.KeySet) *Jwt {
 	return &Jwt{
-		cfg: cfg,
+		cfg:  cfg,
+		keys: keys,
 	}
 }
 
-func (j *Jwt) NewToken(UserId int) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func (j *Jwt) NewToken(UserId int, SessionId uuid.UUID) (string, error) {
+	expirationTime := time.Now().Add(j.cfg.Jwt.AccessTTL)
 
 	usid := strconv.Itoa(UserId)
 	claims := CustomClaims{
-		UserId: UserId,
+		UserId:    UserId,
+		SessionId: SessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "my-golang-app",
+			Issuer:    j.cfg.Jwt.Issuer,
 			Subject:   usid,
 		},
 	}
+	if j.cfg.Jwt.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{j.cfg.Jwt.Audience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString([]byte(j.cfg.Jwt.SecretToken))
+	tokenString, err := j.keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -48,4 +56,18 @@ func (j *Jwt) NewToken(UserId int) (string, error) {
 	return tokenString, nil
 }
 
+func (j *Jwt) ParseToken(tokenString string) (int, uuid.UUID, error) {
+	claims := &CustomClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, j.keys.Keyfunc, keyset.ParserOptions(j.cfg.Jwt)...)
+	if err != nil {
+		return -1, uuid.UUID{}, err
+	}
+	if !token.Valid {
+		return -1, uuid.UUID{}, errors.New("недействительный токен")
+	}
+
+	return claims.UserId, claims.SessionId, nil
+}
+
 var _ interfaces.JwtInterface = (*Jwt)(nil)
@@ -0,0 +1,105 @@
+package middliware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CsrfCookie = "csrf_token"
+	CsrfHeader = "X-CSRF-Token"
+)
+
+// CSRF защищает изменяющие запросы, авторизованные cookie, по схеме
+// double-submit: значение cookie csrf_token должно прийти и в заголовке
+// X-CSRF-Token. Чужой сайт cookie прочитать не может и заголовок не подставит.
+// Запросы с Authorization: Bearer не проверяются — браузер такой заголовок
+// сам не добавляет.
+//
+// Фронтенд на другом источнике из AllowedOrigins не видит cookie API в
+// document.cookie, поэтому токен кладется в контекст под ключом "csrf_token"
+// и отдается ему через GET /api/v1/csrf.
+func CSRF(allowedOrigins []string, secure bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(CsrfCookie)
+			if err != nil || cookie.Value == "" {
+				cookie = issueCsrfCookie(w, secure)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), "csrf_token", cookie.Value))
+
+			if safeMethod(r.Method) || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || !hasAuthCookie(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !OriginAllowed(allowedOrigins, r) {
+				logCsrfRejection(r, "недопустимый Origin")
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			header := r.Header.Get(CsrfHeader)
+			if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+				logCsrfRejection(r, "неверный CSRF-токен")
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func issueCsrfCookie(w http.ResponseWriter, secure bool) *http.Cookie {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		logrus.Error("Не удалось сгенерировать CSRF-токен: ", err)
+		return &http.Cookie{Name: CsrfCookie}
+	}
+
+	cookie := &http.Cookie{
+		Name:     CsrfCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(buf),
+		Path:     "/",
+		HttpOnly: false, // фронтенд читает значение и отправляет его в заголовке
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+
+	return cookie
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func hasAuthCookie(r *http.Request) bool {
+	for _, name := range []string{"UserToken", "RefreshToken"} {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func logCsrfRejection(r *http.Request, reason string) {
+	logrus.WithFields(logrus.Fields{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"origin":  r.Header.Get("Origin"),
+		"referer": r.Header.Get("Referer"),
+		"ip":      r.RemoteAddr,
+	}).Warn("Запрос отклонен защитой от CSRF: ", reason)
+}
@@ -0,0 +1,77 @@
+package middliware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := CSRF([]string{"https://app.example.com"}, true)(ok)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		cookies map[string]string
+		want    int
+	}{
+		{"GET", http.MethodGet, nil, map[string]string{"UserToken": "jwt"}, http.StatusOK},
+		{"без cookie авторизации", http.MethodPost, nil, nil, http.StatusOK},
+		{"Bearer", http.MethodPost, map[string]string{"Authorization": "Bearer ggc_x", "Origin": "https://evil.example"}, map[string]string{"UserToken": "jwt"}, http.StatusOK},
+		{"верный токен", http.MethodPost, map[string]string{CsrfHeader: "t"}, map[string]string{"UserToken": "jwt", CsrfCookie: "t"}, http.StatusOK},
+		{"разрешенный источник", http.MethodPost, map[string]string{CsrfHeader: "t", "Origin": "https://app.example.com"}, map[string]string{"RefreshToken": "r", CsrfCookie: "t"}, http.StatusOK},
+		{"без заголовка", http.MethodPost, nil, map[string]string{"UserToken": "jwt", CsrfCookie: "t"}, http.StatusForbidden},
+		{"неверный токен", http.MethodPut, map[string]string{CsrfHeader: "x"}, map[string]string{"UserToken": "jwt", CsrfCookie: "t"}, http.StatusForbidden},
+		{"без cookie csrf_token", http.MethodPost, map[string]string{CsrfHeader: "t"}, map[string]string{"UserToken": "jwt"}, http.StatusForbidden},
+		{"чужой источник", http.MethodDelete, map[string]string{CsrfHeader: "t", "Origin": "https://evil.example"}, map[string]string{"UserToken": "jwt", CsrfCookie: "t"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://api.example.com/api/v1/chats", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		for k, v := range tt.cookies {
+			r.AddCookie(&http.Cookie{Name: k, Value: v})
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: статус %d, ожидался %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCSRFIssuesCookie(t *testing.T) {
+	var seen string
+	handler := CSRF(nil, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value("csrf_token").(string)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CsrfCookie || cookies[0].Value == "" {
+		t.Fatalf("не выдана cookie %s: %v", CsrfCookie, cookies)
+	}
+	// Фронтенд читает значение из document.cookie
+	if cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Errorf("HttpOnly=%v Secure=%v", cookies[0].HttpOnly, cookies[0].Secure)
+	}
+	// Тот же токен отдает GET /api/v1/csrf
+	if seen != cookies[0].Value {
+		t.Errorf("в контексте %q, в cookie %q", seen, cookies[0].Value)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("существующая cookie выдана заново")
+	}
+}
@@ -1,54 +1,125 @@
 package middliware
 
 import (
+	"GGChat/internal/config"
+	database "GGChat/internal/db"
+	"GGChat/internal/models/crut/tokens"
+	"GGChat/internal/service/keyset"
+	"GGChat/internal/service/token"
+	MyWS "GGChat/internal/websocket"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-// Предполагаем, что CustomClaims и SecretToken определены
+// Предполагаем, что CustomClaims определены
 type CustomClaims struct {
-	UserId int
+	UserId    int
+	SessionId uuid.UUID
 	jwt.RegisteredClaims
 }
 
-// Измененная мидлварь
-func JWTMiddleware(secret string) func(http.Handler) http.Handler {
+// SessionChecker проверяет, что сессия, под которой выпущен токен, не была отозвана
+type SessionChecker interface {
+	TouchSession(ctx context.Context, sessionId uuid.UUID) (bool, error)
+}
+
+// TokenChecker проверяет персональный токен доступа и возвращает его владельца, номер и права
+type TokenChecker interface {
+	CheckAccessToken(ctx context.Context, tokenHash string) (int, int, []string, error)
+}
+
+// TicketChecker погашает одноразовый билет на подключение к WebSocket
+type TicketChecker interface {
+	ConsumeWsTicket(ctx context.Context, ticketHash string) (*tokens.WsTicket, error)
+}
+
+type AuthStore interface {
+	SessionChecker
+	TokenChecker
+	TicketChecker
+	RoleChecker
+	MemberChecker
+}
+
+// JWTMiddleware принимает учетные данные в таком порядке:
+//   - заголовок Authorization: Bearer (JWT или персональный токен ggc_...);
+//   - для WebSocket — токен в Sec-WebSocket-Protocol или одноразовый ?ticket=;
+//   - cookie UserToken.
+//
+// У JWT проверяются подпись, срок действия, issuer и аудитория из cfg.
+func JWTMiddleware(keyfunc jwt.Keyfunc, cfg config.Jwt, store AuthStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrade := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
 
-			cookieHeader, ok := r.Header["Cookie"]
+			if upgrade && r.URL.Query().Has("ticket") {
+				ticket, err := store.ConsumeWsTicket(r.Context(), token.Hash(r.URL.Query().Get("ticket")))
+				if errors.Is(err, database.ErrTicketInvalid) {
+					http.Error(w, "Invalid ticket", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					fmt.Println("Ошибка проверки билета WebSocket:", err)
+					http.Error(w, "Ошибка аутентификации", http.StatusInternalServerError)
+					return
+				}
 
-			if ok && len(cookieHeader) > 0 {
-			} else {
-				fmt.Println("Сырой заголовок Cookie: НЕ НАЙДЕН в r.Header!")
+				ctx := context.WithValue(r.Context(), "user_id", ticket.UserId)
+				if ticket.SessionId != nil {
+					ctx = context.WithValue(ctx, "session_id", *ticket.SessionId)
+				}
+				if ticket.TokenId != nil {
+					ctx = context.WithValue(ctx, "token_id", *ticket.TokenId)
+				}
+				if ticket.Scopes != nil {
+					ctx = context.WithValue(ctx, "scopes", ticket.Scopes)
+				}
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
-			fmt.Println("-------------------------------")
 
-			cookie, err := r.Cookie("UserToken")
-
-			if err != nil {
-				fmt.Println("Ошибка r.Cookie('UserToken'): ", err)
+			tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok && upgrade {
+				tokenString, ok = protocolToken(r)
+			}
+			if !ok {
+				cookie, err := r.Cookie("UserToken")
+				if err != nil {
+					fmt.Println("Токен не найден ни в заголовке, ни в cookie:", err)
+					http.Error(w, "Ошибка аутентификации", http.StatusUnauthorized)
+					return
+				}
+				tokenString = cookie.Value
+			}
 
-				if ok && len(cookieHeader) > 0 {
-					fmt.Println("Сырой заголовок 'Cookie' был, но r.Cookie() не нашла 'UserToken'.")
-					for _, c := range r.Cookies() {
-						fmt.Printf("    Найдено куки: Имя=%s, Значение (часть)=%s...\n", c.Name, c.Value[:10])
-					}
+			// Скрипты и боты авторизуются персональным токеном
+			if strings.HasPrefix(tokenString, tokens.Prefix) {
+				userId, tokenId, scopes, err := store.CheckAccessToken(r.Context(), token.Hash(tokenString))
+				if errors.Is(err, database.ErrTokenNotFound) {
+					http.Error(w, "Invalid Token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					fmt.Println("Ошибка проверки токена доступа:", err)
+					http.Error(w, "Ошибка аутентификации", http.StatusInternalServerError)
+					return
 				}
 
-				http.Error(w, "Ошибка аутентификации", http.StatusUnauthorized)
+				ctx := context.WithValue(r.Context(), "user_id", userId)
+				ctx = context.WithValue(ctx, "token_id", tokenId)
+				ctx = context.WithValue(ctx, "scopes", scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
-			tokenString := cookie.Value
-
 			claims := &CustomClaims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secret), nil
-			})
+			token, err := jwt.ParseWithClaims(tokenString, claims, keyfunc, keyset.ParserOptions(cfg)...)
 
 			if err != nil || !token.Valid {
 				fmt.Println("Ошибка валидации токена:", err)
@@ -56,8 +127,36 @@ func JWTMiddleware(secret string) func(http.Handler) http.Handler {
 				return
 			}
 
+			active, err := store.TouchSession(r.Context(), claims.SessionId)
+			if err != nil {
+				fmt.Println("Ошибка проверки сессии:", err)
+				http.Error(w, "Ошибка аутентификации", http.StatusInternalServerError)
+				return
+			}
+
+			if !active {
+				fmt.Println("Сессия отозвана или истекла:", claims.SessionId)
+				http.Error(w, "Session revoked", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), "user_id", claims.UserId)
+			ctx = context.WithValue(ctx, "session_id", claims.SessionId)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// protocolToken достает токен из Sec-WebSocket-Protocol. Браузерный WebSocket
+// не умеет слать заголовки, поэтому клиент передает список протоколов
+// ["ggchat", "access_token.<token>"], а сервер выбирает "ggchat".
+func protocolToken(r *http.Request) (string, bool) {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(protocol), MyWS.TokenProtocolPrefix); ok && value != "" {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
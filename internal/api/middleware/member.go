@@ -0,0 +1,50 @@
+package middliware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type MemberChecker interface {
+	IsMember(ctx context.Context, chatId uuid.UUID, userId int) (bool, error)
+}
+
+// RequireMember пропускает только участников чата из URL-параметра param.
+// Чужим чатам отвечает 404, чтобы не раскрывать их существование. Ставится
+// внутри Group/With, где параметры маршрута уже известны, и до апгрейда
+// WebSocket. Должна стоять после JWTMiddleware.
+func RequireMember(members MemberChecker, param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, ok := r.Context().Value("user_id").(int)
+			if !ok {
+				http.Error(w, "Ошибка аутентификации", http.StatusUnauthorized)
+				return
+			}
+
+			chatId, err := uuid.Parse(chi.URLParam(r, param))
+			if err != nil {
+				http.Error(w, "Error parsing UUID", http.StatusBadRequest)
+				return
+			}
+
+			member, err := members.IsMember(r.Context(), chatId, userId)
+			if err != nil {
+				fmt.Println("Ошибка проверки участия в чате:", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if !member {
+				http.Error(w, "Chat not found", http.StatusNotFound)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
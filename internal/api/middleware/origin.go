@@ -0,0 +1,30 @@
+package middliware
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// OriginAllowed проверяет заголовок Origin по списку разрешенных источников.
+// Запросы без Origin (не из браузера) и с того же хоста пропускаются.
+func OriginAllowed(allowed []string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	return slices.ContainsFunc(allowed, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), origin)
+	})
+}
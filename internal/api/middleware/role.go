@@ -0,0 +1,48 @@
+package middliware
+
+import (
+	database "GGChat/internal/db"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+type RoleChecker interface {
+	GetUserRole(ctx context.Context, userId int) (string, error)
+}
+
+// RequireRole пропускает только пользователей с одной из указанных ролей.
+// Роль читается из БД на каждый запрос, поэтому понижение вступает в силу сразу.
+// Должна стоять после JWTMiddleware.
+func RequireRole(roles RoleChecker, allowed ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, ok := r.Context().Value("user_id").(int)
+			if !ok {
+				http.Error(w, "Ошибка аутентификации", http.StatusUnauthorized)
+				return
+			}
+
+			role, err := roles.GetUserRole(r.Context(), userId)
+			if errors.Is(err, database.ErrUserNotFound) {
+				http.Error(w, "Ошибка аутентификации", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				fmt.Println("Ошибка проверки роли:", err)
+				http.Error(w, "Ошибка аутентификации", http.StatusInternalServerError)
+				return
+			}
+
+			if !slices.Contains(allowed, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "role", role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package middliware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// RequireScope пропускает запрос, только если у персонального токена есть
+// нужное право. Запросы из браузерной сессии ограничений по правам не имеют.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := r.Context().Value("scopes").([]string)
+			if ok && !slices.Contains(scopes, scope) {
+				http.Error(w, "Insufficient scope: "+scope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession закрывает маршрут для персональных токенов: управление
+// аккаунтом, паролем и самими токенами доступно только из сессии.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("scopes").([]string); ok {
+			http.Error(w, "Session required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,6 +3,9 @@ package api
 import (
 	"GGChat/internal/api/endpoint"
 	"GGChat/internal/config"
+	"GGChat/internal/models/admin"
+	"GGChat/internal/models/crut/tokens"
+	"GGChat/internal/service/keyset"
 	"bufio"
 	"fmt"
 	"net"
@@ -12,6 +15,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
 )
 
 type Api struct {
@@ -19,6 +23,10 @@ type Api struct {
 	apiService *endpoint.ApiVerifications
 	apiChat    *endpoint.ApiChats
 	apiAIChat  *endpoint.AIApiChats
+	apiUsers   *endpoint.ApiUsers
+	apiAdmin   *endpoint.ApiAdmin
+	auth       MyMDL.AuthStore
+	keys       *keyset.KeySet
 	cfg        *config.Config
 }
 
@@ -45,12 +53,16 @@ func (rw *responseWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("responseWrapper: ResponseWriter не реализует http.Hijacker")
 }
 
-func NewApi(apiService *endpoint.ApiVerifications, apiChat *endpoint.ApiChats, apiAIChat *endpoint.AIApiChats, cfg *config.Config) *Api {
+func NewApi(apiService *endpoint.ApiVerifications, apiChat *endpoint.ApiChats, apiAIChat *endpoint.AIApiChats, apiUsers *endpoint.ApiUsers, apiAdmin *endpoint.ApiAdmin, auth MyMDL.AuthStore, keys *keyset.KeySet, cfg *config.Config) *Api {
 	return &Api{
 		router:     nil,
 		apiService: apiService,
 		apiChat:    apiChat,
 		apiAIChat:  apiAIChat,
+		apiUsers:   apiUsers,
+		apiAdmin:   apiAdmin,
+		auth:       auth,
+		keys:       keys,
 		cfg:        cfg,
 	}
 }
@@ -62,11 +74,29 @@ func (a *Api) Init() {
 	a.router.Use(middleware.Logger)
 	a.router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+
+			// CORS-заголовки отдаем только разрешенным источникам
 			clientOrigin := r.Header.Get("Origin")
-			w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, HEAD")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			if clientOrigin != "" {
+				if !MyMDL.OriginAllowed(a.cfg.Security.AllowedOrigins, r) {
+					logrus.WithFields(logrus.Fields{
+						"method": r.Method,
+						"path":   r.URL.Path,
+						"origin": clientOrigin,
+					}).Warn("Запрос с неразрешенного источника")
+
+					if r.Method == "OPTIONS" {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS, HEAD")
+					w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -78,39 +108,155 @@ func (a *Api) Init() {
 
 		})
 	})
+	a.router.Use(MyMDL.CSRF(a.cfg.Security.AllowedOrigins, a.cfg.Jwt.SecureCookie))
+
+	a.router.Get("/api/v1/csrf", a.apiService.GetCsrfToken)
 
 	a.router.Route("/api/v1/users", func(router chi.Router) {
 		router.Post("/verifications", a.apiService.UsersVerifications)
+		router.Post("/verifications/totp", a.apiService.UsersVerificationsTotp)
 		router.Post("/register", a.apiService.UsersRegistrations)
+		router.Post("/refresh", a.apiService.RefreshToken)
+		router.Post("/logout", a.apiService.Logout)
+		router.Post("/password/reset_request", a.apiService.RequestPasswordReset)
+		router.Post("/password/reset", a.apiService.ResetPassword)
+		router.Get("/oidc/providers", a.apiService.GetOidcProviders)
+		router.Get("/oidc/{provider}/login", a.apiService.OidcLogin)
+		router.Get("/oidc/{provider}/callback", a.apiService.OidcCallback)
 
 		router.Group(func(r chi.Router) {
-			r.Use(MyMDL.JWTMiddleware(a.cfg.Jwt.SecretToken))
+			r.Use(MyMDL.JWTMiddleware(a.keys.Keyfunc, a.cfg.Jwt, a.auth))
+
+			r.Group(func(r chi.Router) {
+				r.Use(MyMDL.RequireScope(tokens.ScopeUsersRead))
+
+				r.Get("/me", a.apiUsers.GetMe)
+				r.Get("/search", a.apiUsers.SearchUsers)
+				r.Get("/{id}", a.apiUsers.GetUserProfile)
+				r.Get("/{id}/avatar", a.apiUsers.GetAvatar)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(MyMDL.RequireSession)
+
+				r.Post("/public_key", a.apiChat.SetPublicKey)
+				r.Post("/password", a.apiService.ChangePassword)
+				r.Get("/sessions", a.apiService.GetSessions)
+				r.Delete("/sessions/{id}", a.apiService.DeleteSession)
+
+				r.Get("/tokens", a.apiUsers.GetAccessTokens)
+				r.Post("/tokens", a.apiUsers.CreateAccessToken)
+				r.Delete("/tokens/{id}", a.apiUsers.DeleteAccessToken)
+
+				r.Post("/oidc/{provider}/link", a.apiService.OidcLink)
 
-			r.Post("/public_key", a.apiChat.SetPublicKey)
+				r.Post("/totp/setup", a.apiService.TotpSetup)
+				r.Post("/totp/confirm", a.apiService.TotpConfirm)
+				r.Post("/totp/disable", a.apiService.TotpDisable)
+
+				r.Patch("/me", a.apiUsers.UpdateMe)
+				r.Delete("/me", a.apiService.DeleteMe)
+				r.Post("/me/export", a.apiUsers.ExportMe)
+				r.Post("/me/avatar", a.apiUsers.UploadAvatar)
+				r.Post("/{id}/block", a.apiUsers.BlockUser)
+				r.Delete("/{id}/block", a.apiUsers.UnblockUser)
+			})
 		})
 	})
 
 	a.router.Route("/api/v1/chats", func(router chi.Router) {
-		router.Use(MyMDL.JWTMiddleware(a.cfg.Jwt.SecretToken))
+		router.Use(MyMDL.JWTMiddleware(a.keys.Keyfunc, a.cfg.Jwt, a.auth))
+
+		// Маршруты с {chat_id} доступны только участникам чата
+		member := MyMDL.RequireMember(a.auth, "chat_id")
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireScope(tokens.ScopeChatsRead))
+
+			r.Get("/all_chats", a.apiChat.GetAllChats)
+			r.Get("/folders", a.apiChat.GetFolders)
+			r.Get("/invites/{token}", a.apiChat.PreviewInvite)
+
+			r.With(member).Get("/get_message/{chat_id}", a.apiChat.GetMessage)
+			r.With(member).Get("/public_keys/{chat_id}", a.apiChat.GetChatPublicKeys)
+			r.With(member).Get("/{chat_id}/avatar", a.apiChat.GetChatAvatar)
+			r.With(member).Get("/{chat_id}/members", a.apiChat.GetMembers)
+			r.With(member).Get("/{chat_id}/role_changes", a.apiChat.GetRoleChanges)
+			r.With(member).Get("/{chat_id}/messages/{message_id}/edits", a.apiChat.GetMessageEdits)
+			r.With(member).Get("/{chat_id}/invites", a.apiChat.GetInvites)
+			r.With(member).Get("/{chat_id}/join_requests", a.apiChat.GetJoinRequests)
+		})
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireScope(tokens.ScopeChatsWrite))
 
-		router.Post("/new_chat", a.apiChat.NewChat)
-		router.Delete("/delete_chat/{uuid}", a.apiChat.DeleteChat)
-		router.Get("/all_chats", a.apiChat.GetAllChats)
-		router.Get("/get_message/{chat_id}", a.apiChat.GetMessage)
-		router.Get("/ws/{chat_id}", a.apiChat.HandleWebSocket)
+			r.Post("/new_chat", a.apiChat.NewChat)
+			r.Post("/ws_ticket", a.apiChat.WsTicket)
+			r.Post("/invites/{token}/join", a.apiChat.JoinByInvite)
+			r.Put("/pinned_order", a.apiChat.ReorderPinnedChats)
+			r.Post("/folders", a.apiChat.CreateFolder)
+			r.Patch("/folders/{folder_id}", a.apiChat.RenameFolder)
+			r.Delete("/folders/{folder_id}", a.apiChat.DeleteFolder)
 
-		router.Get("/public_keys/{chat_id}", a.apiChat.GetChatPublicKeys)
+			r.With(member).Delete("/delete_chat/{chat_id}", a.apiChat.DeleteChat)
+			r.With(member).Patch("/{chat_id}", a.apiChat.UpdateChat)
+			r.With(member).Put("/{chat_id}/settings", a.apiChat.UpdateChatSettings)
+			r.With(member).Post("/{chat_id}/leave", a.apiChat.LeaveChat)
+			r.With(member).Post("/{chat_id}/clear_history", a.apiChat.ClearChatHistory)
+			r.With(member).Post("/{chat_id}/avatar", a.apiChat.UploadChatAvatar)
+			r.With(member).Delete("/{chat_id}/avatar", a.apiChat.DeleteChatAvatar)
+			r.With(member).Post("/{chat_id}/members", a.apiChat.AddMembers)
+			r.With(member).Delete("/{chat_id}/members/{user_id}", a.apiChat.RemoveMember)
+			r.With(member).Put("/{chat_id}/members/{user_id}/role", a.apiChat.SetMemberRole)
+			r.With(member).Put("/{chat_id}/messages/{message_id}", a.apiChat.EditMessage)
+			r.With(member).Delete("/{chat_id}/messages/{message_id}", a.apiChat.DeleteMessage)
+			r.With(member).Post("/{chat_id}/invites", a.apiChat.CreateInvite)
+			r.With(member).Delete("/{chat_id}/invites/{invite_id}", a.apiChat.RevokeInvite)
+			r.With(member).Post("/{chat_id}/join_requests/{user_id}/approve", a.apiChat.ApproveJoinRequest)
+			r.With(member).Post("/{chat_id}/join_requests/{user_id}/reject", a.apiChat.RejectJoinRequest)
+			r.With(member).Get("/ws/{chat_id}", a.apiChat.HandleWebSocket)
+		})
 	})
 
 	a.router.Route("/api/v1/ai_chats", func(router chi.Router) {
-		router.Use(MyMDL.JWTMiddleware(a.cfg.Jwt.SecretToken))
-
-		router.Get("/all_chats", a.apiAIChat.GetAllChatsAI)
-		router.Post("/new_chat", a.apiAIChat.CreateChat)
-		router.Post("/new_message", a.apiAIChat.NewMessage)
-		router.Delete("/delete_chat/{id}", a.apiAIChat.DeleteChatAI)
-		router.Get("/messages/{chat_id}", a.apiAIChat.GetMessages)
-		router.Get("/download_doc/{doc}", a.apiAIChat.DownloadDocument)
+		router.Use(MyMDL.JWTMiddleware(a.keys.Keyfunc, a.cfg.Jwt, a.auth))
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireScope(tokens.ScopeAiRead))
+
+			r.Get("/all_chats", a.apiAIChat.GetAllChatsAI)
+			r.Get("/messages/{chat_id}", a.apiAIChat.GetMessages)
+			r.Get("/download_doc/{doc}", a.apiAIChat.DownloadDocument)
+		})
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireScope(tokens.ScopeAiWrite))
+
+			r.Post("/new_chat", a.apiAIChat.CreateChat)
+			r.Post("/new_message", a.apiAIChat.NewMessage)
+			r.Delete("/delete_chat/{id}", a.apiAIChat.DeleteChatAI)
+		})
+	})
+
+	a.router.Route("/api/v1/admin", func(router chi.Router) {
+		router.Use(MyMDL.JWTMiddleware(a.keys.Keyfunc, a.cfg.Jwt, a.auth))
+		router.Use(MyMDL.RequireSession)
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireRole(a.auth, admin.RoleModerator, admin.RoleAdmin))
+
+			r.Get("/users", a.apiAdmin.ListUsers)
+			r.Post("/users/{id}/disable", a.apiAdmin.DisableUser)
+			r.Post("/users/{id}/enable", a.apiAdmin.EnableUser)
+			r.Post("/users/{id}/logout", a.apiAdmin.LogoutUser)
+		})
+
+		router.Group(func(r chi.Router) {
+			r.Use(MyMDL.RequireRole(a.auth, admin.RoleAdmin))
+
+			r.Put("/users/{id}/role", a.apiAdmin.SetRole)
+			r.Get("/stats", a.apiAdmin.GetStats)
+		})
 	})
 }
 
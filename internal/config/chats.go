@@ -0,0 +1,8 @@
+package config
+
+import "time"
+
+type Chats struct {
+	// Сколько после отправки сообщение можно редактировать; 0 — без ограничения.
+	EditWindow time.Duration `yaml:"edit_window" env-default:"48h"`
+}
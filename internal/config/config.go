@@ -6,6 +6,12 @@ type Config struct {
 	App        configo.App      `yaml:"app" env-required:"true"`
 	DatabasePg configo.Database `yaml:"postgres" env-required:"true"`
 	Jwt        Jwt              `yaml:"jwt" env-required:"true"`
+	Lockout    Lockout          `yaml:"lockout"`
+	Mail       Mail             `yaml:"mail"`
+	Storage    Storage          `yaml:"storage"`
+	Oidc       Oidc             `yaml:"oidc"`
+	Security   Security         `yaml:"security"`
+	Chats      Chats            `yaml:"chats"`
 }
 
 func (c Config) Env() string {
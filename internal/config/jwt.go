@@ -1,5 +1,29 @@
 package config
 
+import "time"
+
 type Jwt struct {
-	SecretToken string `yaml:"secret_token" env-required:"true"`
+	// Алгоритм подписи новых ключей: EdDSA или RS256
+	Algorithm string `yaml:"algorithm" env-default:"EdDSA"`
+	// Каталог с ключами в PEM. Если задан, сгенерированные при ротации
+	// ключи сохраняются туда, а все экземпляры сервера подхватывают их оттуда.
+	KeyDir           string        `yaml:"key_dir"`
+	Keys             []JwtKey      `yaml:"keys"`
+	RotationInterval time.Duration `yaml:"rotation_interval" env-default:"168h"`
+	Issuer           string        `yaml:"issuer" env-default:"ggchat"`
+	// Если задан, пишется в aud и обязателен при проверке токена
+	Audience   string        `yaml:"audience"`
+	AccessTTL  time.Duration `yaml:"access_ttl" env-default:"15m"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+	// Браузеры принимают Secure cookie на http://localhost, отключать нужно
+	// только при доступе по http с другого адреса
+	SecureCookie bool `yaml:"secure_cookie" env-default:"true"`
+}
+
+// JwtKey — ключ, заданный в конфиге явно. Если указан только публичный ключ,
+// он используется лишь для проверки подписи.
+type JwtKey struct {
+	Id             string `yaml:"id"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
 }
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+// Lockout задает ограничения на неудачные попытки входа.
+type Lockout struct {
+	// Сколько неудачных попыток для одного имени пользователя допускается до блокировки аккаунта.
+	MaxFailures int `yaml:"max_failures" env-default:"5"`
+	// Сколько неудачных попыток с одного IP допускается до включения задержек.
+	IpMaxFailures int           `yaml:"ip_max_failures" env-default:"20"`
+	BaseDelay     time.Duration `yaml:"base_delay" env-default:"1s"`
+	MaxDelay      time.Duration `yaml:"max_delay" env-default:"1h"`
+	LockDuration  time.Duration `yaml:"lock_duration" env-default:"15m"`
+	// Через сколько после последней ошибки счетчик попыток обнуляется.
+	Window time.Duration `yaml:"window" env-default:"24h"`
+}
@@ -0,0 +1,24 @@
+package config
+
+import "time"
+
+type Mail struct {
+	// smtp — отправка через SMTP-сервер, log — запись писем в файл или лог
+	Driver   string `yaml:"driver" env-default:"log"`
+	Host     string `yaml:"host" env-default:"localhost"`
+	Port     int    `yaml:"port" env-default:"1025"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from" env-default:"GGChat <no-reply@ggchat.local>"`
+	LogPath  string `yaml:"log_path"`
+
+	// Ссылка на страницу сброса пароля, к ней добавляется ?token=...
+	ResetUrl string        `yaml:"reset_url" env-default:"http://localhost:8081/autentifications/reset.html"`
+	ResetTTL time.Duration `yaml:"reset_ttl" env-default:"1h"`
+
+	// Сколько писем сброса можно запросить на один адрес и с одного IP,
+	// пока между запросами проходит меньше reset_window
+	ResetPerEmail int           `yaml:"reset_per_email" env-default:"3"`
+	ResetPerIp    int           `yaml:"reset_per_ip" env-default:"10"`
+	ResetWindow   time.Duration `yaml:"reset_window" env-default:"1h"`
+}
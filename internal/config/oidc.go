@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type Oidc struct {
+	// Сколько живет незавершенный вход через провайдера
+	StateTTL  time.Duration  `yaml:"state_ttl" env-default:"10m"`
+	Providers []OidcProvider `yaml:"providers"`
+}
+
+type OidcProvider struct {
+	// Имя провайдера в URL: /api/v1/users/oidc/{name}/login
+	Name         string   `yaml:"name"`
+	DisplayName  string   `yaml:"display_name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientId     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectUrl  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+	// Создавать нового пользователя, если внешний аккаунт ни с кем не связан
+	AllowSignup bool `yaml:"allow_signup"`
+	// Связывать внешний аккаунт с пользователем по email. Подходят только
+	// адреса, подтвержденные у нас (например, сбросом пароля по ссылке из письма)
+	LinkByEmail bool `yaml:"link_by_email"`
+}
@@ -0,0 +1,7 @@
+package config
+
+type Security struct {
+	// Источники, которым разрешены запросы с cookie и подключение к WebSocket,
+	// например http://localhost:3000. Запросы с того же хоста разрешены всегда.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
@@ -0,0 +1,6 @@
+package config
+
+type Storage struct {
+	AvatarDir     string `yaml:"avatar_dir" env-default:"./storage/avatars"`
+	MaxAvatarSize int64  `yaml:"max_avatar_size" env-default:"2097152"`
+}
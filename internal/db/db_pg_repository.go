@@ -4,23 +4,122 @@ import (
 	"context"
 	"time"
 
+	"GGChat/internal/models/admin"
 	"GGChat/internal/models/chats"
+	"GGChat/internal/models/crut/identities"
+	"GGChat/internal/models/crut/sessions"
+	"GGChat/internal/models/crut/tokens"
+	"GGChat/internal/models/users"
 
 	"github.com/google/uuid"
 )
 
 type PgRepository interface {
 	UsersVerification(ctx context.Context, username, password string) (int, bool, error)
-	NewUser(ctx context.Context, username, password string) (bool, int, error)
+	NewUser(ctx context.Context, username, password, email string) (bool, int, error)
+	ChangePassword(ctx context.Context, userId int, oldPassword, newPassword string) (bool, error)
+	RevokeOtherSessions(ctx context.Context, userId int, keepSessionId uuid.UUID) ([]uuid.UUID, error)
+	GetUserEmail(ctx context.Context, login string) (int, string, error)
+	CreatePasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash, newPassword string) (int, []uuid.UUID, error)
 
-	NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, error)
-	DeleteChat(ctx context.Context, uuid uuid.UUID) error
-	GetAllChats(ctx context.Context, UserId int) ([]chats.Chat, error)
+	GetProfile(ctx context.Context, userId int) (*users.Profile, error)
+	UpdateProfile(ctx context.Context, userId int, req users.UpdateProfileRequest) (*users.Profile, error)
+	SetAvatar(ctx context.Context, userId int, path string) (string, error)
+	GetAvatarPath(ctx context.Context, userId int) (string, error)
+
+	CreateSession(ctx context.Context, userId int, refreshHash, deviceLabel, userAgent, ip string, expiresAt time.Time) (uuid.UUID, error)
+	RotateSession(ctx context.Context, sessionId uuid.UUID, oldHash, newHash string, expiresAt time.Time) (int, error)
+	RevokeSession(ctx context.Context, sessionId uuid.UUID, refreshHash string) error
+	TouchSession(ctx context.Context, sessionId uuid.UUID) (bool, error)
+	GetSessions(ctx context.Context, userId int) ([]sessions.Session, error)
+	RevokeUserSession(ctx context.Context, userId int, sessionId uuid.UUID) error
+
+	GetTotp(ctx context.Context, userId int) (string, string, bool, error)
+	SetTotpSecret(ctx context.Context, userId int, secret string) error
+	EnableTotp(ctx context.Context, userId int, counter int64, recoveryHashes []string) error
+	DisableTotp(ctx context.Context, userId int) error
+	UseTotpCounter(ctx context.Context, userId int, counter int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error)
+	CreateLoginChallenge(ctx context.Context, userId int, expiresAt time.Time) (uuid.UUID, error)
+	CheckLoginChallenge(ctx context.Context, challengeId uuid.UUID, maxAttempts int) (int, error)
+	ConsumeLoginChallenge(ctx context.Context, challengeId uuid.UUID) error
+
+	GetLoginAttempts(ctx context.Context, scope, key string) (int, time.Time, error)
+	RegisterLoginFailure(ctx context.Context, scope, key string, window time.Duration) (int, error)
+	BlockLogin(ctx context.Context, scope, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, scope, key string) error
+	RegisterPasswordResetRequest(ctx context.Context, scope, key string, window time.Duration) (int, error)
+
+	NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, string, error)
+	SavedChat(ctx context.Context, userId int) (uuid.UUID, error)
+	DeleteChat(ctx context.Context, uuid uuid.UUID, actorId int) (string, []int, error)
+	LeaveChat(ctx context.Context, chatId uuid.UUID, userId int) (bool, string, []chats.RoleEvent, error)
+	ClearChatHistory(ctx context.Context, chatId uuid.UUID, userId int) (int, error)
+	GetAllChats(ctx context.Context, UserId int, filter chats.ListFilter) ([]chats.Chat, error)
 	GetUser(ctx context.Context, username string) (int, error)
+	SearchUsers(ctx context.Context, userId int, query string, limit, offset int) ([]users.Summary, error)
+	BlockUser(ctx context.Context, blockerId, blockedId int) error
+	UnblockUser(ctx context.Context, blockerId, blockedId int) error
+	IsBlocked(ctx context.Context, userId, otherUserId int) (bool, error)
+
+	IsMember(ctx context.Context, chatId uuid.UUID, userId int) (bool, error)
+	GetChatInfo(ctx context.Context, chatId uuid.UUID) (*chats.ChatInfo, error)
+	MarkChatRead(ctx context.Context, chatId uuid.UUID, userId, messageId int) error
+	NewGroupChat(ctx context.Context, chatName, chatType string, ownerId int, memberIds []int) (uuid.UUID, error)
+	AddChatMembers(ctx context.Context, chatId uuid.UUID, actorId int, userIds []int) ([]int, error)
+	RemoveChatMember(ctx context.Context, chatId uuid.UUID, actorId, userId int) ([]chats.RoleEvent, error)
+	SetChatMemberRole(ctx context.Context, chatId uuid.UUID, actorId, userId int, role string) ([]chats.RoleEvent, error)
+	GetChatRoleChanges(ctx context.Context, chatId uuid.UUID, actorId, limit int) ([]chats.RoleEvent, error)
+	DeleteChatMessage(ctx context.Context, chatId uuid.UUID, messageId, actorId int) error
+	EditMessage(ctx context.Context, chatId uuid.UUID, messageId, editorId int, content string, keys map[int]string, window time.Duration) (time.Time, map[int]string, error)
+	GetMessageEdits(ctx context.Context, chatId uuid.UUID, messageId, userId int) ([]chats.MessageEdit, error)
+	UpdateChatSettings(ctx context.Context, chatId uuid.UUID, userId int, req chats.SettingsRequest) error
+	ReorderPinnedChats(ctx context.Context, userId int, chatIds []uuid.UUID) error
+	GetNotifyRecipients(ctx context.Context, chatId uuid.UUID, senderId int) ([]int, error)
+	GetChatFolders(ctx context.Context, userId int) ([]chats.Folder, error)
+	CreateChatFolder(ctx context.Context, userId int, name string) (*chats.Folder, error)
+	RenameChatFolder(ctx context.Context, userId, folderId int, name string) error
+	DeleteChatFolder(ctx context.Context, userId, folderId int) error
+	UpdateChat(ctx context.Context, chatId uuid.UUID, actorId int, req chats.UpdateChatRequest) (*chats.ChatInfo, error)
+	SetChatAvatar(ctx context.Context, chatId uuid.UUID, actorId int, path string) (string, *chats.ChatInfo, error)
+	GetChatAvatarPath(ctx context.Context, chatId uuid.UUID) (string, error)
+
+	CreateChatInvite(ctx context.Context, chatId uuid.UUID, actorId int, tokenHash string, req chats.InviteRequest, expiresAt *time.Time) (*chats.Invite, error)
+	GetChatInvites(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.Invite, error)
+	RevokeChatInvite(ctx context.Context, chatId uuid.UUID, actorId, inviteId int) error
+	PreviewChatInvite(ctx context.Context, tokenHash string, userId int) (*chats.InvitePreview, error)
+	JoinChatByInvite(ctx context.Context, tokenHash string, userId int) (uuid.UUID, string, error)
+	GetJoinRequests(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.JoinRequest, error)
+	DecideJoinRequest(ctx context.Context, chatId uuid.UUID, actorId, userId int, approve bool) error
+	GetChatMembers(ctx context.Context, chatId uuid.UUID) ([]chats.Member, error)
+
+	CheckPassword(ctx context.Context, userId int, pass string) (bool, error)
+	ExportUserData(ctx context.Context, userId int) (*users.Export, error)
+	DeleteUser(ctx context.Context, userId int) ([]uuid.UUID, string, error)
+
+	CreateAccessToken(ctx context.Context, userId int, name string, scopes []string, tokenHash string, expiresAt *time.Time) (*tokens.AccessToken, error)
+	GetAccessTokens(ctx context.Context, userId int) ([]tokens.AccessToken, error)
+	RevokeAccessToken(ctx context.Context, userId, tokenId int) error
+	CheckAccessToken(ctx context.Context, tokenHash string) (int, int, []string, error)
+	CreateWsTicket(ctx context.Context, ticketHash string, ticket tokens.WsTicket, expiresAt time.Time) error
+	ConsumeWsTicket(ctx context.Context, ticketHash string) (*tokens.WsTicket, error)
+
+	GetUserRole(ctx context.Context, userId int) (string, error)
+	AdminListUsers(ctx context.Context, query string, limit, offset int) ([]admin.User, int, error)
+	SetUserDisabled(ctx context.Context, userId int, disabled bool) ([]uuid.UUID, error)
+	RevokeAllSessions(ctx context.Context, userId int) ([]uuid.UUID, error)
+	SetUserRole(ctx context.Context, userId int, role string) error
+	GetStats(ctx context.Context, days int) (*admin.Stats, error)
+
+	CreateOidcState(ctx context.Context, stateHash string, state identities.LoginState, expiresAt time.Time) error
+	ConsumeOidcState(ctx context.Context, stateHash, provider, bindingHash string) (*identities.LoginState, error)
+	LoginWithIdentity(ctx context.Context, ext identities.External, allowSignup, linkByEmail bool) (int, error)
+	LinkIdentity(ctx context.Context, userId int, ext identities.External) error
 
 	NewMessage(ctx context.Context, chatId uuid.UUID, senderId int, encryptedContent string, encryptedKeys map[int]string) (int, string, error)
 	GetMessage(ctx context.Context, chatId uuid.UUID, currentUserId int) ([]chats.Message, error)
-	UpdateMessageStatus(ctx context.Context, messageId int, status string) error
+	UpdateMessageStatus(ctx context.Context, chatId uuid.UUID, messageId, userId int, status string) (bool, error)
 	AddPublicKey(ctx context.Context, userId int, publicKey string) error
 	GetPublicKeysForChat(ctx context.Context, chatId uuid.UUID, senderId int) (map[int]string, error)
 
@@ -0,0 +1,32 @@
+package db
+
+import "errors"
+
+var (
+	ErrSessionNotFound    = errors.New("сессия не найдена, отозвана или истекла")
+	ErrRefreshTokenReused = errors.New("повторное использование refresh-токена, сессия отозвана")
+	ErrChallengeNotFound  = errors.New("запрос на подтверждение входа не найден или истек")
+	ErrResetTokenInvalid  = errors.New("ссылка для сброса пароля недействительна или истекла")
+	ErrUserNotFound       = errors.New("пользователь не найден")
+	ErrEmailTaken         = errors.New("email уже используется другим пользователем")
+	ErrTokenNotFound      = errors.New("токен доступа не найден, отозван или истек")
+	ErrUserDisabled       = errors.New("аккаунт заблокирован")
+	ErrOidcStateInvalid   = errors.New("запрос на вход через провайдера не найден или истек")
+	ErrIdentityNotLinked  = errors.New("внешний аккаунт не связан ни с одним пользователем")
+	ErrIdentityTaken      = errors.New("внешний аккаунт уже связан с другим пользователем")
+	ErrTicketInvalid      = errors.New("билет WebSocket не найден, использован или истек")
+	ErrChatNotFound       = errors.New("чат не найден")
+	ErrNotGroupChat       = errors.New("участников можно менять только в групповом чате")
+	ErrChatForbidden      = errors.New("недостаточно прав в чате")
+	ErrMemberNotFound     = errors.New("пользователь не состоит в чате")
+	ErrMessageNotFound    = errors.New("сообщение не найдено")
+	ErrInviteInvalid      = errors.New("приглашение не найдено, отозвано, истекло или исчерпано")
+	ErrJoinRequestMissing = errors.New("заявка на вступление не найдена")
+	ErrChatNameRequired   = errors.New("у группового чата должно быть название")
+	ErrFolderNotFound     = errors.New("папка не найдена")
+	ErrPinLimit           = errors.New("закреплено слишком много чатов")
+	ErrEditWindowExpired  = errors.New("время на редактирование сообщения истекло")
+	ErrUserBlocked        = errors.New("один из пользователей заблокировал другого")
+	ErrChatFull           = errors.New("в чате максимальное число участников")
+	ErrChannelKeys        = errors.New("сообщения канала хранятся без шифрования, ключи к ним не принимаются")
+)
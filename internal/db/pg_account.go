@@ -0,0 +1,289 @@
+package db
+
+import (
+	"GGChat/internal/models/users"
+	"GGChat/internal/service/password"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (repo *RepositoryPg) ExportUserData(ctx context.Context, userId int) (*users.Export, error) {
+	profile, err := repo.GetProfile(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &users.Export{Profile: profile}
+
+	const qChats = `
+		SELECT c.uuid, COALESCE(c.name, ''), cn.joined_at
+		FROM chat_numbers cn
+		JOIN chats c ON c.uuid = cn.chat_id
+		WHERE cn.user_id = $1
+		ORDER BY cn.joined_at
+	`
+	rows, err := repo.db.Query(ctx, qChats, userId)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выгрузке чатов: %w", err)
+	}
+	export.Chats, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (users.ExportChat, error) {
+		var chat users.ExportChat
+		err := row.Scan(&chat.Uuid, &chat.Name, &chat.JoinedAt)
+		return chat, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выгрузке чатов: %w", err)
+	}
+
+	const qMessages = `
+		SELECT m.id, m.chat_id, m.content, mk.encrypted_key, m.sent_at
+		FROM message m
+		LEFT JOIN message_keys mk ON mk.message_id = m.id AND mk.user_id = $1
+		WHERE m.sender_id = $1
+		ORDER BY m.id
+	`
+	rows, err = repo.db.Query(ctx, qMessages, userId)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выгрузке сообщений: %w", err)
+	}
+	export.Messages, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (users.ExportMessage, error) {
+		var message users.ExportMessage
+		err := row.Scan(&message.Id, &message.ChatId, &message.Content, &message.EncryptedKey, &message.SentAt)
+		return message, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выгрузке сообщений: %w", err)
+	}
+
+	const qAiChats = `
+		SELECT id, title, created_at, donedocpath, finalfilename
+		FROM ai_chats
+		WHERE user_id = $1
+		ORDER BY id
+	`
+	rows, err = repo.db.Query(ctx, qAiChats, userId)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выгрузке AI-чатов: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chat users.ExportAiChat
+		var docPath, docName *string
+		if err := rows.Scan(&chat.Id, &chat.Title, &chat.CreatedAt, &docPath, &docName); err != nil {
+			return nil, fmt.Errorf("ошибка при выгрузке AI-чатов: %w", err)
+		}
+		export.AiChats = append(export.AiChats, chat)
+
+		if docPath != nil && *docPath != "" {
+			document := users.ExportDocument{AiChatId: chat.Id, Path: *docPath}
+			if docName != nil {
+				document.Name = *docName
+			}
+			export.Documents = append(export.Documents, document)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по результатам: %w", err)
+	}
+
+	const qAiMessages = `
+		SELECT content, sender_type, COALESCE(sent_at, now())
+		FROM ai_messages
+		WHERE chat_id = $1
+		ORDER BY id
+	`
+	for i := range export.AiChats {
+		rows, err := repo.db.Query(ctx, qAiMessages, export.AiChats[i].Id)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при выгрузке AI-сообщений: %w", err)
+		}
+		export.AiChats[i].Messages, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (users.ExportAiMessage, error) {
+			var message users.ExportAiMessage
+			err := row.Scan(&message.Content, &message.SenderType, &message.SentAt)
+			return message, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при выгрузке AI-сообщений: %w", err)
+		}
+	}
+
+	return export, nil
+}
+
+// DeleteUser удаляет персональные данные пользователя одной транзакцией.
+// Отправленные сообщения остаются у собеседников, но автор обезличивается:
+// строка users сохраняется под именем deleted_<id> без пароля и профиля.
+// Возвращает отозванные сессии и путь к аватару, который нужно удалить с диска.
+func (repo *RepositoryPg) DeleteUser(ctx context.Context, userId int) ([]uuid.UUID, string, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var avatarPath string
+	const qUser = `
+		SELECT COALESCE(avatar_path, '') FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`
+	if err := tx.QueryRow(ctx, qUser, userId).Scan(&avatarPath); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, "", ErrUserNotFound
+		}
+		return nil, "", fmt.Errorf("ошибка при поиске пользователя: %w", err)
+	}
+
+	// Чаты, в которых после ухода пользователя никого не останется
+	const qEmptyChats = `
+		SELECT cn.chat_id FROM chat_numbers cn
+		WHERE cn.user_id = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM chat_numbers other
+			WHERE other.chat_id = cn.chat_id AND other.user_id != $1
+		)
+	`
+	rows, err := tx.Query(ctx, qEmptyChats, userId)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка при поиске чатов пользователя: %w", err)
+	}
+	emptyChats, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка при поиске чатов пользователя: %w", err)
+	}
+
+	// Групповые чаты, которые пользователь покидает владельцем
+	const qOwnedChats = `
+		SELECT chat_id FROM chat_numbers
+		WHERE user_id = $1 AND role = 'owner'
+	`
+	rows, err = tx.Query(ctx, qOwnedChats, userId)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка при поиске чатов пользователя: %w", err)
+	}
+	ownedChats, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка при поиске чатов пользователя: %w", err)
+	}
+
+	statements := []string{
+		`DELETE FROM message_keys WHERE user_id = $1`,
+		`DELETE FROM message_status WHERE user_id = $1`,
+		`UPDATE message_edits SET encrypted_keys = encrypted_keys - $1::int4::text WHERE encrypted_keys ? $1::int4::text`,
+		`DELETE FROM chat_numbers WHERE user_id = $1`,
+		`DELETE FROM ai_messages WHERE chat_id IN (SELECT id FROM ai_chats WHERE user_id = $1)`,
+		`DELETE FROM ai_chats WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM login_challenges WHERE user_id = $1`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+		`DELETE FROM user_blocks WHERE blocker_id = $1 OR blocked_id = $1`,
+		`DELETE FROM access_tokens WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM chat_join_requests WHERE user_id = $1`,
+		`DELETE FROM chat_folders WHERE user_id = $1`,
+		`UPDATE chat_invites SET revoked_at = now() WHERE created_by = $1 AND revoked_at IS NULL`,
+		`UPDATE users SET
+			username = 'deleted_' || id,
+			password = NULL,
+			email = NULL,
+			public_key = NULL,
+			display_name = NULL,
+			avatar_path = NULL,
+			bio = NULL,
+			timezone = NULL,
+			totp_secret = NULL,
+			totp_enabled = false,
+			totp_last_counter = NULL,
+			discoverable = false,
+			deleted_at = now()
+		WHERE id = $1`,
+	}
+	for _, q := range statements {
+		if _, err := tx.Exec(ctx, q, userId); err != nil {
+			return nil, "", fmt.Errorf("ошибка при удалении данных пользователя: %w", err)
+		}
+	}
+
+	for _, chatId := range ownedChats {
+		if _, err := promoteHeir(ctx, tx, chatId, userId); err != nil {
+			return nil, "", err
+		}
+	}
+
+	const qDeleteChat = `
+		WITH removed AS (
+			DELETE FROM message WHERE chat_id = $1 RETURNING id
+		), keys AS (
+			DELETE FROM message_keys WHERE message_id IN (SELECT id FROM removed)
+		), statuses AS (
+			DELETE FROM message_status WHERE message_id IN (SELECT id FROM removed)
+		), edits AS (
+			DELETE FROM message_edits WHERE message_id IN (SELECT id FROM removed)
+		), roles AS (
+			DELETE FROM chat_role_changes WHERE chat_id = $1
+		), invites AS (
+			DELETE FROM chat_invites WHERE chat_id = $1
+		), requests AS (
+			DELETE FROM chat_join_requests WHERE chat_id = $1
+		)
+		DELETE FROM chats WHERE uuid = $1
+	`
+	for _, chatId := range emptyChats {
+		if _, err := tx.Exec(ctx, qDeleteChat, chatId); err != nil {
+			return nil, "", fmt.Errorf("ошибка при удалении пустого чата: %w", err)
+		}
+	}
+
+	const qSessions = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`
+	rows, err = tx.Query(ctx, qSessions, userId)
+	if err != nil {
+		return nil, "", fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+	revoked, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, "", fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, "", fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return revoked, avatarPath, nil
+}
+
+func (repo *RepositoryPg) CheckPassword(ctx context.Context, userId int, pass string) (bool, error) {
+	const q = `
+		SELECT COALESCE(password, '') FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	var storedPassword string
+	err := repo.db.QueryRow(ctx, q, userId).Scan(&storedPassword)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	}
+
+	if storedPassword == "" {
+		return false, nil
+	}
+
+	ok, _, err := password.Verify(pass, storedPassword)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при проверке пароля: %w", err)
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"GGChat/internal/models/admin"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (repo *RepositoryPg) GetUserRole(ctx context.Context, userId int) (string, error) {
+	const q = `
+		SELECT role FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	var role string
+	err := repo.db.QueryRow(ctx, q, userId).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при получении роли пользователя: %w", err)
+	}
+
+	return role, nil
+}
+
+func (repo *RepositoryPg) AdminListUsers(ctx context.Context, query string, limit, offset int) ([]admin.User, int, error) {
+	const q = `
+		SELECT u.id, u.username, COALESCE(u.display_name, u.username), u.email, u.role, u.disabled_at,
+			(SELECT max(s.last_seen_at) FROM sessions s WHERE s.user_id = u.id),
+			count(*) OVER ()
+		FROM users u
+		WHERE u.deleted_at IS NULL
+		AND ($1 = '' OR u.username ILIKE $2 OR u.display_name ILIKE $2 OR u.email ILIKE $2)
+		ORDER BY u.id
+		LIMIT $3 OFFSET $4
+	`
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	rows, err := repo.db.Query(ctx, q, query, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка при получении списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	result := []admin.User{}
+	total := 0
+	for rows.Next() {
+		var user admin.User
+		if err := rows.Scan(&user.Id, &user.Username, &user.DisplayName, &user.Email, &user.Role, &user.DisabledAt, &user.LastSeenAt, &total); err != nil {
+			return nil, 0, fmt.Errorf("ошибка при сканировании пользователя: %w", err)
+		}
+		result = append(result, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка при итерации по результатам: %w", err)
+	}
+
+	return result, total, nil
+}
+
+// SetUserDisabled блокирует или разблокирует аккаунт. При блокировке
+// все сессии пользователя отзываются, их id возвращаются вызывающему коду.
+func (repo *RepositoryPg) SetUserDisabled(ctx context.Context, userId int, disabled bool) ([]uuid.UUID, error) {
+	const q = `
+		UPDATE users
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, now()) END
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, userId, disabled)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось изменить статус пользователя: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	if !disabled {
+		return nil, nil
+	}
+
+	return repo.RevokeAllSessions(ctx, userId)
+}
+
+func (repo *RepositoryPg) RevokeAllSessions(ctx context.Context, userId int) ([]uuid.UUID, error) {
+	const q = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`
+
+	rows, err := repo.db.Query(ctx, q, userId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+func (repo *RepositoryPg) SetUserRole(ctx context.Context, userId int, role string) error {
+	const q = `
+		UPDATE users SET role = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, userId, role)
+	if err != nil {
+		return fmt.Errorf("не удалось изменить роль пользователя: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) GetStats(ctx context.Context, days int) (*admin.Stats, error) {
+	const q = `
+		SELECT
+			(SELECT count(*) FROM users WHERE deleted_at IS NULL),
+			(SELECT count(*) FROM users WHERE deleted_at IS NULL AND disabled_at IS NOT NULL),
+			(SELECT count(*) FROM sessions WHERE revoked_at IS NULL AND expires_at > now()),
+			(SELECT count(*) FROM chats),
+			(SELECT count(*) FROM message),
+			(SELECT count(*) FROM ai_chats)
+	`
+
+	var stats admin.Stats
+	err := repo.db.QueryRow(ctx, q).Scan(
+		&stats.Users,
+		&stats.DisabledUsers,
+		&stats.ActiveSessions,
+		&stats.Chats,
+		&stats.Messages,
+		&stats.AiChats,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении статистики: %w", err)
+	}
+
+	const qDocs = `
+		SELECT to_char(d.day, 'YYYY-MM-DD'), count(g.id)
+		FROM generate_series(current_date - ($1::int - 1), current_date, interval '1 day') AS d(day)
+		LEFT JOIN document_generations g ON g.created_at::date = d.day::date
+		GROUP BY d.day
+		ORDER BY d.day
+	`
+
+	rows, err := repo.db.Query(ctx, qDocs, days)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении статистики документов: %w", err)
+	}
+
+	stats.DocumentsPerDay, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (admin.DayCount, error) {
+		var day admin.DayCount
+		err := row.Scan(&day.Day, &day.Count)
+		return day, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении статистики документов: %w", err)
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"GGChat/internal/models/chats"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxPinnedChats — сколько чатов пользователь может закрепить
+const MaxPinnedChats = 10
+
+// UpdateChatSettings меняет личные настройки чата: архив, отключение
+// уведомлений, закрепление и папку
+func (repo *RepositoryPg) UpdateChatSettings(ctx context.Context, chatId uuid.UUID, userId int, req chats.SettingsRequest) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const qCurrent = `
+		SELECT pinned_order IS NOT NULL FROM chat_numbers
+		WHERE chat_id = $1 AND user_id = $2
+		FOR UPDATE
+	`
+
+	var pinned bool
+	err = tx.QueryRow(ctx, qCurrent, chatId, userId).Scan(&pinned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrChatNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при получении настроек чата: %w", err)
+	}
+
+	if req.Pinned != nil && *req.Pinned && !pinned {
+		const qCount = `
+			SELECT count(*) FROM chat_numbers
+			WHERE user_id = $1 AND pinned_order IS NOT NULL
+		`
+
+		var count int
+		if err = tx.QueryRow(ctx, qCount, userId).Scan(&count); err != nil {
+			return fmt.Errorf("ошибка при подсчете закрепленных чатов: %w", err)
+		}
+		if count >= MaxPinnedChats {
+			return ErrPinLimit
+		}
+	}
+
+	if req.FolderId != nil && *req.FolderId != 0 {
+		const qFolder = `
+			SELECT EXISTS (SELECT 1 FROM chat_folders WHERE id = $1 AND user_id = $2)
+		`
+
+		var exists bool
+		if err = tx.QueryRow(ctx, qFolder, *req.FolderId, userId).Scan(&exists); err != nil {
+			return fmt.Errorf("ошибка при проверке папки: %w", err)
+		}
+		if !exists {
+			return ErrFolderNotFound
+		}
+	}
+
+	// Новый закрепленный чат встает в конец списка закрепленных
+	const q = `
+		UPDATE chat_numbers SET
+			archived = COALESCE($3, archived),
+			muted_until = CASE
+				WHEN $4::int4 IS NULL THEN muted_until
+				WHEN $4 = 0 THEN NULL
+				WHEN $4 < 0 THEN now() + interval '100 years'
+				ELSE now() + make_interval(secs => $4)
+			END,
+			pinned_order = CASE
+				WHEN $5::boolean IS NULL THEN pinned_order
+				WHEN NOT $5 THEN NULL
+				ELSE COALESCE(pinned_order, (
+					SELECT COALESCE(max(pinned_order), 0) + 1 FROM chat_numbers
+					WHERE user_id = $2
+				))
+			END,
+			folder_id = CASE WHEN $6::int4 IS NULL THEN folder_id ELSE NULLIF($6, 0) END
+		WHERE chat_id = $1 AND user_id = $2
+	`
+
+	if _, err = tx.Exec(ctx, q, chatId, userId, req.Archived, req.MuteFor, req.Pinned, req.FolderId); err != nil {
+		return fmt.Errorf("не удалось изменить настройки чата: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+// ReorderPinnedChats задает порядок закрепленных чатов. Чаты, которых нет
+// в списке, открепляются.
+func (repo *RepositoryPg) ReorderPinnedChats(ctx context.Context, userId int, chatIds []uuid.UUID) error {
+	if len(chatIds) > MaxPinnedChats {
+		return ErrPinLimit
+	}
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const qReset = `
+		UPDATE chat_numbers SET pinned_order = NULL
+		WHERE user_id = $1 AND pinned_order IS NOT NULL
+	`
+
+	if _, err = tx.Exec(ctx, qReset, userId); err != nil {
+		return fmt.Errorf("не удалось открепить чаты: %w", err)
+	}
+
+	const q = `
+		UPDATE chat_numbers cn SET pinned_order = pinned.position
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS pinned(chat_id, position)
+		WHERE cn.user_id = $1 AND cn.chat_id = pinned.chat_id
+	`
+
+	result, err := tx.Exec(ctx, q, userId, chatIds)
+	if err != nil {
+		return fmt.Errorf("не удалось закрепить чаты: %w", err)
+	}
+	if int(result.RowsAffected()) != len(chatIds) {
+		return ErrChatNotFound
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+// GetNotifyRecipients возвращает участников чата, кроме отправителя,
+// у которых не отключены уведомления
+func (repo *RepositoryPg) GetNotifyRecipients(ctx context.Context, chatId uuid.UUID, senderId int) ([]int, error) {
+	const q = `
+		SELECT user_id FROM chat_numbers
+		WHERE chat_id = $1 AND user_id != $2
+		AND (muted_until IS NULL OR muted_until <= now())
+	`
+
+	rows, err := repo.db.Query(ctx, q, chatId, senderId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить получателей уведомлений: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+func (repo *RepositoryPg) GetChatFolders(ctx context.Context, userId int) ([]chats.Folder, error) {
+	const q = `
+		SELECT f.id, f.name, f.position,
+			(SELECT count(*) FROM chat_numbers WHERE user_id = f.user_id AND folder_id = f.id)
+		FROM chat_folders f
+		WHERE f.user_id = $1
+		ORDER BY f.position, f.id
+	`
+
+	rows, err := repo.db.Query(ctx, q, userId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить список папок: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.Folder, error) {
+		var folder chats.Folder
+		err := row.Scan(&folder.Id, &folder.Name, &folder.Position, &folder.ChatCount)
+		return folder, err
+	})
+}
+
+func (repo *RepositoryPg) CreateChatFolder(ctx context.Context, userId int, name string) (*chats.Folder, error) {
+	const q = `
+		INSERT INTO chat_folders (user_id, name, position)
+		VALUES ($1, $2, (SELECT COALESCE(max(position), 0) + 1 FROM chat_folders WHERE user_id = $1))
+		RETURNING id, name, position
+	`
+
+	var folder chats.Folder
+	if err := repo.db.QueryRow(ctx, q, userId, name).Scan(&folder.Id, &folder.Name, &folder.Position); err != nil {
+		return nil, fmt.Errorf("не удалось создать папку: %w", err)
+	}
+
+	return &folder, nil
+}
+
+func (repo *RepositoryPg) RenameChatFolder(ctx context.Context, userId, folderId int, name string) error {
+	const q = `
+		UPDATE chat_folders SET name = $3
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := repo.db.Exec(ctx, q, folderId, userId, name)
+	if err != nil {
+		return fmt.Errorf("не удалось переименовать папку: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrFolderNotFound
+	}
+
+	return nil
+}
+
+// DeleteChatFolder удаляет папку; чаты из нее остаются в общем списке
+func (repo *RepositoryPg) DeleteChatFolder(ctx context.Context, userId, folderId int) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		DELETE FROM chat_folders
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := tx.Exec(ctx, q, folderId, userId)
+	if err != nil {
+		return fmt.Errorf("не удалось удалить папку: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrFolderNotFound
+	}
+
+	const qChats = `
+		UPDATE chat_numbers SET folder_id = NULL
+		WHERE user_id = $1 AND folder_id = $2
+	`
+
+	if _, err = tx.Exec(ctx, qChats, userId, folderId); err != nil {
+		return fmt.Errorf("не удалось убрать чаты из папки: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,866 @@
+package db
+
+import (
+	"GGChat/internal/models/chats"
+	"GGChat/internal/models/users"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// NewGroupChat создает групповой чат или канал. Создатель становится
+// его владельцем.
+func (repo *RepositoryPg) NewGroupChat(ctx context.Context, chatName, chatType string, ownerId int, memberIds []int) (uuid.UUID, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		INSERT INTO chats (name, type, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING uuid
+	`
+
+	var chatId uuid.UUID
+	if err = tx.QueryRow(ctx, q, chatName, chatType, ownerId).Scan(&chatId); err != nil {
+		return chatId, fmt.Errorf("не удалось создать групповой чат: %w", err)
+	}
+
+	const p = `
+		INSERT INTO chat_numbers (chat_id, user_id, role)
+		SELECT $1, member, CASE WHEN member = $2 THEN 'owner' ELSE 'member' END
+		FROM unnest($3::int4[]) AS member
+		ON CONFLICT DO NOTHING
+	`
+
+	if _, err = tx.Exec(ctx, p, chatId, ownerId, append([]int{ownerId}, memberIds...)); err != nil {
+		return chatId, fmt.Errorf("не удалось добавить участников чата: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return chatId, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return chatId, nil
+}
+
+// directKey — ключ личного чата, одинаковый для пары пользователей в любом
+// порядке. Чат пользователя с самим собой — его «Избранное».
+func directKey(userId, otherUserId int) string {
+	return fmt.Sprintf("%d:%d", min(userId, otherUserId), max(userId, otherUserId))
+}
+
+// createSavedChat создает пользователю чат «Избранное», если его еще нет
+func createSavedChat(ctx context.Context, tx pgx.Tx, userId int) (uuid.UUID, error) {
+	const q = `
+		INSERT INTO chats (name, type, direct_key, created_by)
+		VALUES ($1, 'saved', $2, $3)
+		ON CONFLICT (direct_key) DO NOTHING
+		RETURNING uuid
+	`
+
+	var chatId uuid.UUID
+	err := tx.QueryRow(ctx, q, chats.SavedChatName, directKey(userId, userId), userId).Scan(&chatId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		const e = `SELECT uuid FROM chats WHERE direct_key = $1`
+		if err = tx.QueryRow(ctx, e, directKey(userId, userId)).Scan(&chatId); err != nil {
+			return chatId, fmt.Errorf("ошибка при поиске чата «Избранное»: %w", err)
+		}
+		return chatId, nil
+	}
+	if err != nil {
+		return chatId, fmt.Errorf("не удалось создать чат «Избранное»: %w", err)
+	}
+
+	const p = `
+		INSERT INTO chat_numbers (chat_id, user_id, role)
+		VALUES ($1, $2, 'owner')
+	`
+
+	if _, err = tx.Exec(ctx, p, chatId, userId); err != nil {
+		return chatId, fmt.Errorf("не удалось создать чат «Избранное»: %w", err)
+	}
+
+	return chatId, nil
+}
+
+// SavedChat возвращает чат «Избранное» пользователя, создавая его при
+// необходимости (например, если пользователь его удалил)
+func (repo *RepositoryPg) SavedChat(ctx context.Context, userId int) (uuid.UUID, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatId, err := createSavedChat(ctx, tx, userId)
+	if err != nil {
+		return chatId, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return chatId, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return chatId, nil
+}
+
+func (repo *RepositoryPg) IsMember(ctx context.Context, chatId uuid.UUID, userId int) (bool, error) {
+	const q = `
+		SELECT EXISTS (
+			SELECT 1 FROM chat_numbers
+			WHERE chat_id = $1 AND user_id = $2
+		)
+	`
+
+	var member bool
+	if err := repo.db.QueryRow(ctx, q, chatId, userId).Scan(&member); err != nil {
+		return false, fmt.Errorf("ошибка при проверке участия в чате: %w", err)
+	}
+
+	return member, nil
+}
+
+// chatRole возвращает тип чата и роль в нем пользователя. Строка чата
+// блокируется до конца транзакции, чтобы изменения состава и ролей
+// одного чата не выполнялись параллельно.
+func chatRole(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, userId int) (string, string, error) {
+	const q = `
+		SELECT c.type, cn.role
+		FROM chats c
+		JOIN chat_numbers cn ON cn.chat_id = c.uuid AND cn.user_id = $2
+		WHERE c.uuid = $1
+		FOR UPDATE OF c
+	`
+
+	var chatType, role string
+	err := tx.QueryRow(ctx, q, chatId, userId).Scan(&chatType, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", ErrChatNotFound
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка при получении роли в чате: %w", err)
+	}
+
+	return chatType, role, nil
+}
+
+// memberRole возвращает роль участника чата, уже заблокированного chatRole
+func memberRole(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, userId int) (string, error) {
+	const q = `
+		SELECT role FROM chat_numbers
+		WHERE chat_id = $1 AND user_id = $2
+	`
+
+	var role string
+	err := tx.QueryRow(ctx, q, chatId, userId).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrMemberNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при получении роли в чате: %w", err)
+	}
+
+	return role, nil
+}
+
+func setRole(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, actorId, userId int, oldRole, newRole string) (chats.RoleEvent, error) {
+	const q = `
+		UPDATE chat_numbers SET role = $3
+		WHERE chat_id = $1 AND user_id = $2
+	`
+	if _, err := tx.Exec(ctx, q, chatId, userId, newRole); err != nil {
+		return chats.RoleEvent{}, fmt.Errorf("не удалось изменить роль: %w", err)
+	}
+
+	return logRoleChange(ctx, tx, chatId, actorId, userId, oldRole, newRole)
+}
+
+func logRoleChange(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, actorId, userId int, oldRole, newRole string) (chats.RoleEvent, error) {
+	const q = `
+		INSERT INTO chat_role_changes (chat_id, actor_id, user_id, old_role, new_role)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+
+	event := chats.RoleEvent{ActorId: actorId, UserId: userId, OldRole: oldRole, NewRole: newRole}
+	if err := tx.QueryRow(ctx, q, chatId, actorId, userId, oldRole, newRole).Scan(&event.CreatedAt); err != nil {
+		return event, fmt.Errorf("не удалось записать смену роли в журнал: %w", err)
+	}
+
+	return event, nil
+}
+
+// promoteHeir назначает нового владельца чата, если после ухода actorId
+// владельцев не осталось: сначала самого давнего администратора, иначе
+// самого давнего участника
+func promoteHeir(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, actorId int) ([]chats.RoleEvent, error) {
+	const q = `
+		SELECT user_id, role FROM chat_numbers
+		WHERE chat_id = $1
+		AND NOT EXISTS (
+			SELECT 1 FROM chat_numbers WHERE chat_id = $1 AND role = 'owner'
+		)
+		ORDER BY role = 'admin' DESC, joined_at, user_id
+		LIMIT 1
+	`
+
+	var heirId int
+	var oldRole string
+	err := tx.QueryRow(ctx, q, chatId).Scan(&heirId, &oldRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при поиске нового владельца чата: %w", err)
+	}
+
+	event, err := setRole(ctx, tx, chatId, actorId, heirId, oldRole, chats.RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return []chats.RoleEvent{event}, nil
+}
+
+// AddChatMembers добавляет участников в групповой чат и возвращает тех,
+// кого в нем еще не было. Если в группе станет больше MaxGroupMembers
+// участников, никто не добавляется и возвращается ErrChatFull
+func (repo *RepositoryPg) AddChatMembers(ctx context.Context, chatId uuid.UUID, actorId int, userIds []int) ([]int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatType, role, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return nil, err
+	}
+	if !chats.IsShared(chatType) {
+		return nil, ErrNotGroupChat
+	}
+	if !chats.Can(role, chats.PermManageMembers) {
+		return nil, ErrChatForbidden
+	}
+
+	const q = `
+		INSERT INTO chat_numbers (chat_id, user_id)
+		SELECT $1, unnest($2::int4[])
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`
+
+	rows, err := tx.Query(ctx, q, chatId, userIds)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось добавить участников чата: %w", err)
+	}
+
+	added, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("не удалось добавить участников чата: %w", err)
+	}
+
+	// Строка чата заблокирована в chatRole, поэтому параллельные добавления
+	// и вступления по приглашению ждут и видят уже итоговый состав
+	if chatType != chats.TypeChannel && len(added) > 0 {
+		const qCount = `SELECT count(*) FROM chat_numbers WHERE chat_id = $1`
+
+		var count int
+		if err = tx.QueryRow(ctx, qCount, chatId).Scan(&count); err != nil {
+			return nil, fmt.Errorf("ошибка при подсчете участников чата: %w", err)
+		}
+		if count > chats.MaxGroupMembers {
+			return nil, ErrChatFull
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return added, nil
+}
+
+// RemoveChatMember исключает участника из группового чата. Выйти может любой
+// участник, исключать — администраторы и владельцы, причем только тех, чья
+// роль ниже. Если ушел последний владелец, возвращается назначение преемника.
+func (repo *RepositoryPg) RemoveChatMember(ctx context.Context, chatId uuid.UUID, actorId, userId int) ([]chats.RoleEvent, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatType, actorRole, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return nil, err
+	}
+	if !chats.IsShared(chatType) {
+		return nil, ErrNotGroupChat
+	}
+
+	targetRole, err := memberRole(ctx, tx, chatId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if actorId != userId {
+		if !chats.Can(actorRole, chats.PermManageMembers) || chats.RoleRank(actorRole) <= chats.RoleRank(targetRole) {
+			return nil, ErrChatForbidden
+		}
+	}
+
+	const q = `
+		DELETE FROM chat_numbers
+		WHERE chat_id = $1 AND user_id = $2
+	`
+
+	if _, err = tx.Exec(ctx, q, chatId, userId); err != nil {
+		return nil, fmt.Errorf("не удалось исключить участника чата: %w", err)
+	}
+
+	var events []chats.RoleEvent
+	if targetRole == chats.RoleOwner {
+		if events, err = promoteHeir(ctx, tx, chatId, actorId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return events, nil
+}
+
+// LeaveChat выводит пользователя из чата любого типа. Его ключи и статусы
+// сообщений удаляются, остальные участники сохраняют переписку. Если в чате
+// никого не осталось, он удаляется целиком: тогда deleted равен true,
+// а avatarPath указывает на файл аватара, который нужно удалить.
+func (repo *RepositoryPg) LeaveChat(ctx context.Context, chatId uuid.UUID, userId int) (bool, string, []chats.RoleEvent, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return false, "", nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatType, role, err := chatRole(ctx, tx, chatId, userId)
+	if err != nil {
+		return false, "", nil, err
+	}
+
+	for _, q := range []string{
+		`DELETE FROM message_keys WHERE user_id = $2 AND message_id IN (SELECT id FROM message WHERE chat_id = $1)`,
+		`DELETE FROM message_status WHERE user_id = $2 AND message_id IN (SELECT id FROM message WHERE chat_id = $1)`,
+		`UPDATE message_edits SET encrypted_keys = encrypted_keys - $2::int4::text WHERE message_id IN (SELECT id FROM message WHERE chat_id = $1)`,
+		`DELETE FROM chat_join_requests WHERE chat_id = $1 AND user_id = $2`,
+		`DELETE FROM chat_numbers WHERE chat_id = $1 AND user_id = $2`,
+	} {
+		if _, err = tx.Exec(ctx, q, chatId, userId); err != nil {
+			return false, "", nil, fmt.Errorf("не удалось выйти из чата: %w", err)
+		}
+	}
+
+	const qLeft = `SELECT EXISTS (SELECT 1 FROM chat_numbers WHERE chat_id = $1)`
+
+	var remaining bool
+	if err = tx.QueryRow(ctx, qLeft, chatId).Scan(&remaining); err != nil {
+		return false, "", nil, fmt.Errorf("ошибка при проверке участников чата: %w", err)
+	}
+
+	var avatarPath string
+	var events []chats.RoleEvent
+	if !remaining {
+		if avatarPath, _, err = purgeChat(ctx, tx, chatId); err != nil {
+			return false, "", nil, fmt.Errorf("не удалось удалить опустевший чат: %w", err)
+		}
+	} else if chats.IsShared(chatType) && role == chats.RoleOwner {
+		if events, err = promoteHeir(ctx, tx, chatId, userId); err != nil {
+			return false, "", nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return false, "", nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return !remaining, avatarPath, events, nil
+}
+
+// ClearChatHistory скрывает от пользователя все текущие сообщения чата,
+// не затрагивая остальных участников. Возвращает id последнего скрытого
+// сообщения.
+func (repo *RepositoryPg) ClearChatHistory(ctx context.Context, chatId uuid.UUID, userId int) (int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		WITH last AS (
+			SELECT COALESCE(max(id), 0) AS id FROM message WHERE chat_id = $1
+		)
+		UPDATE chat_numbers cn
+		SET cleared_up_to = last.id,
+			last_read_message_id = GREATEST(COALESCE(cn.last_read_message_id, 0), last.id)
+		FROM last
+		WHERE cn.chat_id = $1 AND cn.user_id = $2
+		RETURNING cn.cleared_up_to
+	`
+
+	var clearedUpTo int
+	err = tx.QueryRow(ctx, q, chatId, userId).Scan(&clearedUpTo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrChatNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("не удалось очистить историю чата: %w", err)
+	}
+
+	// Скрытые сообщения пользователю больше не покажутся, их ключи и
+	// статусы для него не нужны
+	for _, q := range []string{
+		`DELETE FROM message_keys WHERE user_id = $2 AND message_id IN (SELECT id FROM message WHERE chat_id = $1 AND id <= $3)`,
+		`DELETE FROM message_status WHERE user_id = $2 AND message_id IN (SELECT id FROM message WHERE chat_id = $1 AND id <= $3)`,
+		`UPDATE message_edits SET encrypted_keys = encrypted_keys - $2::int4::text WHERE message_id IN (SELECT id FROM message WHERE chat_id = $1 AND id <= $3)`,
+	} {
+		if _, err = tx.Exec(ctx, q, chatId, userId, clearedUpTo); err != nil {
+			return 0, fmt.Errorf("не удалось очистить историю чата: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return clearedUpTo, nil
+}
+
+// SetChatMemberRole меняет роль участника группового чата. Назначение
+// нового владельца передает ему права: прежний владелец становится
+// администратором.
+func (repo *RepositoryPg) SetChatMemberRole(ctx context.Context, chatId uuid.UUID, actorId, userId int, role string) ([]chats.RoleEvent, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatType, actorRole, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return nil, err
+	}
+	if !chats.IsShared(chatType) {
+		return nil, ErrNotGroupChat
+	}
+	if !chats.Can(actorRole, chats.PermChangeRoles) || actorId == userId {
+		return nil, ErrChatForbidden
+	}
+
+	oldRole, err := memberRole(ctx, tx, chatId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if oldRole == role {
+		return nil, nil
+	}
+
+	event, err := setRole(ctx, tx, chatId, actorId, userId, oldRole, role)
+	if err != nil {
+		return nil, err
+	}
+	events := []chats.RoleEvent{event}
+
+	if role == chats.RoleOwner {
+		event, err = setRole(ctx, tx, chatId, actorId, actorId, actorRole, chats.RoleAdmin)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetChatRoleChanges возвращает журнал смены ролей. Журнал видят
+// администраторы и владельцы чата.
+func (repo *RepositoryPg) GetChatRoleChanges(ctx context.Context, chatId uuid.UUID, actorId, limit int) ([]chats.RoleEvent, error) {
+	const q = `
+		SELECT rc.actor_id, rc.user_id, COALESCE(rc.old_role, ''), COALESCE(rc.new_role, ''), rc.created_at
+		FROM chat_role_changes rc
+		JOIN chat_numbers cn ON cn.chat_id = rc.chat_id AND cn.user_id = $2
+		WHERE rc.chat_id = $1 AND cn.role IN ('owner', 'admin')
+		ORDER BY rc.created_at DESC, rc.id DESC
+		LIMIT $3
+	`
+
+	rows, err := repo.db.Query(ctx, q, chatId, actorId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить журнал ролей: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.RoleEvent, error) {
+		var event chats.RoleEvent
+		err := row.Scan(&event.ActorId, &event.UserId, &event.OldRole, &event.NewRole, &event.CreatedAt)
+		return event, err
+	})
+}
+
+// DeleteChatMessage удаляет сообщение. Свое сообщение может удалить любой
+// участник, чужое — администраторы и владельцы.
+func (repo *RepositoryPg) DeleteChatMessage(ctx context.Context, chatId uuid.UUID, messageId, actorId int) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, role, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return err
+	}
+
+	const q = `
+		SELECT sender_id FROM message
+		WHERE id = $1 AND chat_id = $2
+	`
+
+	var senderId int
+	err = tx.QueryRow(ctx, q, messageId, chatId).Scan(&senderId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при поиске сообщения: %w", err)
+	}
+
+	if senderId != actorId && !chats.Can(role, chats.PermDeleteMessages) {
+		return ErrChatForbidden
+	}
+
+	statements := []string{
+		`DELETE FROM message_keys WHERE message_id = $1`,
+		`DELETE FROM message_status WHERE message_id = $1`,
+		`DELETE FROM message_edits WHERE message_id = $1`,
+		`DELETE FROM message WHERE id = $1`,
+	}
+	for _, q := range statements {
+		if _, err := tx.Exec(ctx, q, messageId); err != nil {
+			return fmt.Errorf("не удалось удалить сообщение: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+// EditMessage заменяет текст сообщения и ключи к нему, сохраняя прежнюю
+// версию в message_edits. Редактировать может только отправитель и только
+// в течение window после отправки (0 — без ограничения). Возвращает время
+// правки и сохраненные ключи: их получают только текущие участники.
+// В канале текст хранится открытым, и правка с ключами отклоняется
+// с ErrChannelKeys.
+func (repo *RepositoryPg) EditMessage(ctx context.Context, chatId uuid.UUID, messageId, editorId int, content string, keys map[int]string, window time.Duration) (time.Time, map[int]string, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Окно редактирования считается в SQL: sent_at заполняется now() базы
+	// без часового пояса, и сравнение с часами Go сдвинулось бы на смещение от UTC
+	const q = `
+		SELECT m.sender_id, $3::float8 <= 0 OR m.sent_at > now() - make_interval(secs => $3::float8), c.type
+		FROM message m
+		JOIN chats c ON c.uuid = m.chat_id
+		WHERE m.id = $1 AND m.chat_id = $2
+		FOR UPDATE OF m
+	`
+
+	var senderId int
+	var editable bool
+	var chatType string
+	err = tx.QueryRow(ctx, q, messageId, chatId, window.Seconds()).Scan(&senderId, &editable, &chatType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("ошибка при поиске сообщения: %w", err)
+	}
+
+	if senderId != editorId {
+		return time.Time{}, nil, ErrChatForbidden
+	}
+	if !editable {
+		return time.Time{}, nil, ErrEditWindowExpired
+	}
+	if chatType == chats.TypeChannel && len(keys) > 0 {
+		return time.Time{}, nil, ErrChannelKeys
+	}
+
+	const qHistory = `
+		INSERT INTO message_edits (message_id, content, encrypted_keys)
+		SELECT m.id, m.content, COALESCE((
+			SELECT jsonb_object_agg(mk.user_id::text, mk.encrypted_key)
+			FROM message_keys mk
+			WHERE mk.message_id = m.id
+		), '{}')
+		FROM message m
+		WHERE m.id = $1
+	`
+
+	if _, err = tx.Exec(ctx, qHistory, messageId); err != nil {
+		return time.Time{}, nil, fmt.Errorf("не удалось сохранить прежнюю версию сообщения: %w", err)
+	}
+
+	const qUpdate = `
+		UPDATE message SET content = $2, edited_at = now()
+		WHERE id = $1
+		RETURNING edited_at
+	`
+
+	var editedAt time.Time
+	if err = tx.QueryRow(ctx, qUpdate, messageId, content).Scan(&editedAt); err != nil {
+		return time.Time{}, nil, fmt.Errorf("не удалось изменить сообщение: %w", err)
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM message_keys WHERE message_id = $1`, messageId); err != nil {
+		return time.Time{}, nil, fmt.Errorf("не удалось заменить ключи сообщения: %w", err)
+	}
+
+	var stored map[int]string
+	if chatType != chats.TypeChannel {
+		// Как и при отправке, ключи сохраняем только для текущих участников
+		const qKeys = `
+			INSERT INTO message_keys (message_id, user_id, encrypted_key)
+			SELECT $1, cn.user_id, k.encrypted_key
+			FROM jsonb_each_text($3::jsonb) AS k(user_id, encrypted_key)
+			JOIN chat_numbers cn ON cn.chat_id = $2 AND cn.user_id = k.user_id::int4
+			RETURNING user_id, encrypted_key
+		`
+
+		rows, err := tx.Query(ctx, qKeys, messageId, chatId, keys)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("не удалось заменить ключи сообщения: %w", err)
+		}
+
+		stored = make(map[int]string)
+		var userId int
+		var encKey string
+		_, err = pgx.ForEachRow(rows, []any{&userId, &encKey}, func() error {
+			stored[userId] = encKey
+			return nil
+		})
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("не удалось заменить ключи сообщения: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return time.Time{}, nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return editedAt, stored, nil
+}
+
+// GetMessageEdits возвращает прежние версии сообщения, от старых к новым,
+// с ключами запросившего участника
+func (repo *RepositoryPg) GetMessageEdits(ctx context.Context, chatId uuid.UUID, messageId, userId int) ([]chats.MessageEdit, error) {
+	const q = `
+		SELECT e.content, e.encrypted_keys ->> $3::int4::text, e.replaced_at
+		FROM message_edits e
+		JOIN message m ON m.id = e.message_id
+		JOIN chat_numbers cn ON cn.chat_id = m.chat_id AND cn.user_id = $3
+		WHERE e.message_id = $1 AND m.chat_id = $2
+		AND m.id > COALESCE(cn.cleared_up_to, 0)
+		ORDER BY e.id
+	`
+
+	rows, err := repo.db.Query(ctx, q, messageId, chatId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить историю правок: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.MessageEdit, error) {
+		var edit chats.MessageEdit
+		err := row.Scan(&edit.Content, &edit.EncryptedKey, &edit.ReplacedAt)
+		return edit, err
+	})
+}
+
+func (repo *RepositoryPg) GetChatMembers(ctx context.Context, chatId uuid.UUID) ([]chats.Member, error) {
+	const q = `
+		SELECT u.id, COALESCE(u.display_name, u.username, ''), u.avatar_path IS NOT NULL,
+			u.public_key, cn.role, cn.joined_at
+		FROM chat_numbers cn
+		JOIN users u ON u.id = cn.user_id
+		WHERE cn.chat_id = $1
+		ORDER BY cn.joined_at, u.id
+	`
+
+	rows, err := repo.db.Query(ctx, q, chatId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить участников чата: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.Member, error) {
+		var member chats.Member
+		var hasAvatar bool
+		err := row.Scan(&member.UserId, &member.Name, &hasAvatar, &member.PublicKey, &member.Role, &member.JoinedAt)
+		member.AvatarUrl = users.AvatarUrl(member.UserId, hasAvatar)
+		return member, err
+	})
+}
+
+const chatInfoColumns = `uuid, type, COALESCE(name, ''), description, avatar_path IS NOT NULL, updated_at`
+
+func scanChatInfo(row pgx.Row) (*chats.ChatInfo, error) {
+	var info chats.ChatInfo
+	var hasAvatar bool
+	if err := row.Scan(&info.Uuid, &info.Type, &info.Name, &info.Description, &hasAvatar, &info.UpdatedAt); err != nil {
+		return nil, err
+	}
+	info.AvatarUrl = chats.AvatarUrl(info.Uuid, hasAvatar)
+	return &info, nil
+}
+
+// UpdateChat меняет название и описание чата. Пустое описание удаляет его,
+// пустое название допустимо только у личного чата.
+func (repo *RepositoryPg) UpdateChat(ctx context.Context, chatId uuid.UUID, actorId int, req chats.UpdateChatRequest) (*chats.ChatInfo, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	chatType, role, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return nil, err
+	}
+	if !chats.Can(role, chats.PermRenameChat) {
+		return nil, ErrChatForbidden
+	}
+	if chatType != chats.TypeDirect && req.Name != nil && *req.Name == "" {
+		return nil, ErrChatNameRequired
+	}
+
+	const q = `
+		UPDATE chats SET
+			name = CASE WHEN $2::text IS NULL THEN name ELSE NULLIF($2, '') END,
+			description = CASE WHEN $3::text IS NULL THEN description ELSE NULLIF($3, '') END,
+			updated_at = now()
+		WHERE uuid = $1
+		RETURNING ` + chatInfoColumns
+
+	info, err := scanChatInfo(tx.QueryRow(ctx, q, chatId, req.Name, req.Description))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось обновить чат: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return info, nil
+}
+
+// SetChatAvatar сохраняет путь к аватару чата (пустой путь удаляет аватар)
+// и возвращает путь к прежнему файлу
+func (repo *RepositoryPg) SetChatAvatar(ctx context.Context, chatId uuid.UUID, actorId int, path string) (string, *chats.ChatInfo, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, role, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return "", nil, err
+	}
+	if !chats.Can(role, chats.PermRenameChat) {
+		return "", nil, ErrChatForbidden
+	}
+
+	const qOld = `SELECT COALESCE(avatar_path, '') FROM chats WHERE uuid = $1`
+
+	var oldPath string
+	if err = tx.QueryRow(ctx, qOld, chatId).Scan(&oldPath); err != nil {
+		return "", nil, fmt.Errorf("ошибка при получении аватара чата: %w", err)
+	}
+
+	const q = `
+		UPDATE chats SET avatar_path = NULLIF($2, ''), updated_at = now()
+		WHERE uuid = $1
+		RETURNING ` + chatInfoColumns
+
+	info, err := scanChatInfo(tx.QueryRow(ctx, q, chatId, path))
+	if err != nil {
+		return "", nil, fmt.Errorf("не удалось сохранить аватар чата: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return oldPath, info, nil
+}
+
+func (repo *RepositoryPg) GetChatInfo(ctx context.Context, chatId uuid.UUID) (*chats.ChatInfo, error) {
+	const q = `SELECT ` + chatInfoColumns + ` FROM chats WHERE uuid = $1`
+
+	info, err := scanChatInfo(repo.db.QueryRow(ctx, q, chatId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении чата: %w", err)
+	}
+
+	return info, nil
+}
+
+// MarkChatRead сдвигает отметку прочтения участника. По ней считаются
+// непрочитанные сообщения каналов, для которых message_status не ведется.
+func (repo *RepositoryPg) MarkChatRead(ctx context.Context, chatId uuid.UUID, userId, messageId int) error {
+	const q = `
+		UPDATE chat_numbers
+		SET last_read_message_id = GREATEST(COALESCE(last_read_message_id, 0), $3)
+		WHERE chat_id = $1 AND user_id = $2
+	`
+
+	if _, err := repo.db.Exec(ctx, q, chatId, userId, messageId); err != nil {
+		return fmt.Errorf("ошибка при обновлении отметки прочтения: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) GetChatAvatarPath(ctx context.Context, chatId uuid.UUID) (string, error) {
+	const q = `SELECT COALESCE(avatar_path, '') FROM chats WHERE uuid = $1`
+
+	var path string
+	err := repo.db.QueryRow(ctx, q, chatId).Scan(&path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrChatNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при получении аватара чата: %w", err)
+	}
+
+	return path, nil
+}
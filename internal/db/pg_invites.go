@@ -0,0 +1,391 @@
+package db
+
+import (
+	"GGChat/internal/models/chats"
+	"GGChat/internal/models/users"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// inviteManager проверяет, что actorId может управлять приглашениями в чат
+func inviteManager(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, actorId int, perm chats.Permission) error {
+	chatType, role, err := chatRole(ctx, tx, chatId, actorId)
+	if err != nil {
+		return err
+	}
+	if !chats.IsShared(chatType) {
+		return ErrNotGroupChat
+	}
+	if !chats.Can(role, perm) {
+		return ErrChatForbidden
+	}
+
+	return nil
+}
+
+// admitMember проверяет, можно ли пустить userId в чат от имени actorId
+// (автора приглашения или одобрившего заявку): между ними нет блокировки, а
+// в групповом чате есть место. Строка чата блокируется, чтобы параллельные
+// вступления не превысили лимит.
+func admitMember(ctx context.Context, tx pgx.Tx, chatId uuid.UUID, userId, actorId int) error {
+	blocked, err := isBlocked(ctx, tx, userId, actorId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+
+	const q = `
+		SELECT type FROM chats
+		WHERE uuid = $1
+		FOR UPDATE
+	`
+
+	var chatType string
+	err = tx.QueryRow(ctx, q, chatId).Scan(&chatType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrChatNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при получении чата: %w", err)
+	}
+
+	if chatType == chats.TypeChannel {
+		return nil
+	}
+
+	const qCount = `SELECT count(*) FROM chat_numbers WHERE chat_id = $1`
+
+	var count int
+	if err = tx.QueryRow(ctx, qCount, chatId).Scan(&count); err != nil {
+		return fmt.Errorf("ошибка при подсчете участников чата: %w", err)
+	}
+	if count+1 > chats.MaxGroupMembers {
+		return ErrChatFull
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) CreateChatInvite(ctx context.Context, chatId uuid.UUID, actorId int, tokenHash string, req chats.InviteRequest, expiresAt *time.Time) (*chats.Invite, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = inviteManager(ctx, tx, chatId, actorId, chats.PermManageInvites); err != nil {
+		return nil, err
+	}
+
+	const q = `
+		INSERT INTO chat_invites (chat_id, token_hash, created_by, requires_approval, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_by, requires_approval, max_uses, uses, expires_at, created_at
+	`
+
+	var invite chats.Invite
+	err = tx.QueryRow(ctx, q, chatId, tokenHash, actorId, req.RequiresApproval, req.MaxUses, expiresAt).Scan(
+		&invite.Id,
+		&invite.CreatedBy,
+		&invite.RequiresApproval,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.ExpiresAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать приглашение: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return &invite, nil
+}
+
+// GetChatInvites возвращает действующие приглашения в чат
+func (repo *RepositoryPg) GetChatInvites(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.Invite, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = inviteManager(ctx, tx, chatId, actorId, chats.PermManageInvites); err != nil {
+		return nil, err
+	}
+
+	const q = `
+		SELECT id, created_by, requires_approval, max_uses, uses, expires_at, created_at
+		FROM chat_invites
+		WHERE chat_id = $1 AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		AND (max_uses IS NULL OR uses < max_uses)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := tx.Query(ctx, q, chatId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить список приглашений: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.Invite, error) {
+		var invite chats.Invite
+		err := row.Scan(&invite.Id, &invite.CreatedBy, &invite.RequiresApproval, &invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.CreatedAt)
+		return invite, err
+	})
+}
+
+func (repo *RepositoryPg) RevokeChatInvite(ctx context.Context, chatId uuid.UUID, actorId, inviteId int) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = inviteManager(ctx, tx, chatId, actorId, chats.PermManageInvites); err != nil {
+		return err
+	}
+
+	const q = `
+		UPDATE chat_invites
+		SET revoked_at = now()
+		WHERE id = $1 AND chat_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := tx.Exec(ctx, q, inviteId, chatId)
+	if err != nil {
+		return fmt.Errorf("не удалось отозвать приглашение: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInviteInvalid
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) PreviewChatInvite(ctx context.Context, tokenHash string, userId int) (*chats.InvitePreview, error) {
+	const q = `
+		SELECT c.uuid, COALESCE(c.name, ''), c.type,
+			(SELECT count(*) FROM chat_numbers WHERE chat_id = c.uuid),
+			i.requires_approval,
+			EXISTS (SELECT 1 FROM chat_numbers WHERE chat_id = c.uuid AND user_id = $2)
+		FROM chat_invites i
+		JOIN chats c ON c.uuid = i.chat_id
+		WHERE i.token_hash = $1 AND i.revoked_at IS NULL
+		AND (i.expires_at IS NULL OR i.expires_at > now())
+		AND (i.max_uses IS NULL OR i.uses < i.max_uses)
+	`
+
+	var preview chats.InvitePreview
+	err := repo.db.QueryRow(ctx, q, tokenHash, userId).Scan(
+		&preview.ChatId,
+		&preview.Name,
+		&preview.Type,
+		&preview.MemberCount,
+		&preview.RequiresApproval,
+		&preview.IsMember,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInviteInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при проверке приглашения: %w", err)
+	}
+
+	return &preview, nil
+}
+
+// JoinChatByInvite вступает в чат по приглашению. Возвращает чат и статус:
+// approved — пользователь участник чата, pending — заявка ждет одобрения.
+func (repo *RepositoryPg) JoinChatByInvite(ctx context.Context, tokenHash string, userId int) (uuid.UUID, string, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return uuid.UUID{}, "", fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		SELECT i.id, i.chat_id, i.created_by, i.requires_approval,
+			EXISTS (SELECT 1 FROM chat_numbers WHERE chat_id = i.chat_id AND user_id = $2)
+		FROM chat_invites i
+		WHERE i.token_hash = $1 AND i.revoked_at IS NULL
+		AND (i.expires_at IS NULL OR i.expires_at > now())
+		AND (i.max_uses IS NULL OR i.uses < i.max_uses)
+		FOR UPDATE
+	`
+
+	var inviteId, createdBy int
+	var chatId uuid.UUID
+	var requiresApproval, member bool
+	err = tx.QueryRow(ctx, q, tokenHash, userId).Scan(&inviteId, &chatId, &createdBy, &requiresApproval, &member)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return chatId, "", ErrInviteInvalid
+	}
+	if err != nil {
+		return chatId, "", fmt.Errorf("ошибка при проверке приглашения: %w", err)
+	}
+
+	if member {
+		return chatId, chats.JoinApproved, nil
+	}
+
+	status := chats.JoinApproved
+	if requiresApproval {
+		// Место в чате проверится при одобрении заявки
+		blocked, err := isBlocked(ctx, tx, userId, createdBy)
+		if err != nil {
+			return chatId, "", err
+		}
+		if blocked {
+			return chatId, "", ErrUserBlocked
+		}
+
+		// Повторная заявка, пока прежняя ждет решения, приглашение не расходует
+		const qRequest = `
+			INSERT INTO chat_join_requests (chat_id, user_id, invite_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (chat_id, user_id) DO UPDATE
+			SET status = 'pending', invite_id = EXCLUDED.invite_id, created_at = now(),
+				decided_by = NULL, decided_at = NULL
+			WHERE chat_join_requests.status != 'pending'
+		`
+
+		result, err := tx.Exec(ctx, qRequest, chatId, userId, inviteId)
+		if err != nil {
+			return chatId, "", fmt.Errorf("не удалось создать заявку на вступление: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return chatId, chats.JoinPending, nil
+		}
+		status = chats.JoinPending
+	} else {
+		if err = admitMember(ctx, tx, chatId, userId, createdBy); err != nil {
+			return chatId, "", err
+		}
+
+		const qJoin = `
+			INSERT INTO chat_numbers (chat_id, user_id)
+			VALUES ($1, $2)
+		`
+
+		if _, err = tx.Exec(ctx, qJoin, chatId, userId); err != nil {
+			return chatId, "", fmt.Errorf("не удалось вступить в чат: %w", err)
+		}
+	}
+
+	const qUse = `
+		UPDATE chat_invites SET uses = uses + 1
+		WHERE id = $1
+	`
+
+	if _, err = tx.Exec(ctx, qUse, inviteId); err != nil {
+		return chatId, "", fmt.Errorf("не удалось учесть использование приглашения: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return chatId, "", fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return chatId, status, nil
+}
+
+func (repo *RepositoryPg) GetJoinRequests(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.JoinRequest, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = inviteManager(ctx, tx, chatId, actorId, chats.PermManageMembers); err != nil {
+		return nil, err
+	}
+
+	const q = `
+		SELECT u.id, COALESCE(u.display_name, u.username, ''), u.avatar_path IS NOT NULL,
+			jr.invite_id, jr.created_at
+		FROM chat_join_requests jr
+		JOIN users u ON u.id = jr.user_id
+		WHERE jr.chat_id = $1 AND jr.status = 'pending' AND u.deleted_at IS NULL
+		ORDER BY jr.created_at
+	`
+
+	rows, err := tx.Query(ctx, q, chatId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить заявки на вступление: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chats.JoinRequest, error) {
+		var request chats.JoinRequest
+		var hasAvatar bool
+		err := row.Scan(&request.UserId, &request.Name, &hasAvatar, &request.InviteId, &request.CreatedAt)
+		request.AvatarUrl = users.AvatarUrl(request.UserId, hasAvatar)
+		return request, err
+	})
+}
+
+// DecideJoinRequest одобряет или отклоняет заявку на вступление
+func (repo *RepositoryPg) DecideJoinRequest(ctx context.Context, chatId uuid.UUID, actorId, userId int, approve bool) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = inviteManager(ctx, tx, chatId, actorId, chats.PermManageMembers); err != nil {
+		return err
+	}
+
+	status := chats.JoinRejected
+	if approve {
+		status = chats.JoinApproved
+	}
+
+	const q = `
+		UPDATE chat_join_requests
+		SET status = $3, decided_by = $4, decided_at = now()
+		WHERE chat_id = $1 AND user_id = $2 AND status = 'pending'
+	`
+
+	result, err := tx.Exec(ctx, q, chatId, userId, status, actorId)
+	if err != nil {
+		return fmt.Errorf("не удалось обработать заявку на вступление: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrJoinRequestMissing
+	}
+
+	if approve {
+		if err = admitMember(ctx, tx, chatId, userId, actorId); err != nil {
+			return err
+		}
+
+		const qJoin = `
+			INSERT INTO chat_numbers (chat_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`
+
+		if _, err = tx.Exec(ctx, qJoin, chatId, userId); err != nil {
+			return fmt.Errorf("не удалось добавить участника чата: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"GGChat/internal/models/crut/identities"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// Сколько случайных суффиксов перебирается для имени нового пользователя
+	usernameAttempts = 5
+	maxUsernameBase  = 32
+)
+
+func (repo *RepositoryPg) CreateOidcState(ctx context.Context, stateHash string, state identities.LoginState, expiresAt time.Time) error {
+	const q = `
+		INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, binding_hash, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := repo.db.Exec(ctx, q, stateHash, state.Provider, state.CodeVerifier, state.Nonce, state.BindingHash, state.LinkUserId, expiresAt)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить запрос на вход через провайдера: %w", err)
+	}
+
+	// Заодно чистим просроченные записи
+	const d = `DELETE FROM oidc_login_states WHERE expires_at < now()`
+	if _, err := repo.db.Exec(ctx, d); err != nil {
+		return fmt.Errorf("не удалось удалить просроченные запросы на вход: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeOidcState возвращает и удаляет запрос на вход — state одноразовый.
+// Запрос принимается только от браузера, который его начал: чужой state с
+// другим bindingHash не найдется и не будет израсходован.
+func (repo *RepositoryPg) ConsumeOidcState(ctx context.Context, stateHash, provider, bindingHash string) (*identities.LoginState, error) {
+	const q = `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND provider = $2 AND binding_hash = $3 AND expires_at > now()
+		RETURNING provider, code_verifier, nonce, binding_hash, link_user_id
+	`
+
+	var state identities.LoginState
+	err := repo.db.QueryRow(ctx, q, stateHash, provider, bindingHash).Scan(&state.Provider, &state.CodeVerifier, &state.Nonce, &state.BindingHash, &state.LinkUserId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOidcStateInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при поиске запроса на вход: %w", err)
+	}
+
+	return &state, nil
+}
+
+// LoginWithIdentity находит пользователя по внешнему аккаунту. Если связи
+// еще нет, она создается по email (linkByEmail), если адрес подтвержден и
+// провайдером, и у нас, или вместе с новым пользователем (allowSignup).
+func (repo *RepositoryPg) LoginWithIdentity(ctx context.Context, ext identities.External, allowSignup, linkByEmail bool) (int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return -1, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const qIdentity = `
+		UPDATE user_identities i
+		SET last_login_at = now(), email = NULLIF($3, '')
+		FROM users u
+		WHERE i.provider = $1 AND i.subject = $2 AND u.id = i.user_id
+		RETURNING u.id, u.deleted_at IS NOT NULL, u.disabled_at IS NOT NULL
+	`
+
+	var userId int
+	var deleted, disabled bool
+	err = tx.QueryRow(ctx, qIdentity, ext.Provider, ext.Subject, ext.Email).Scan(&userId, &deleted, &disabled)
+	switch {
+	case err == nil:
+		if deleted {
+			return -1, ErrUserNotFound
+		}
+		if disabled {
+			return -1, ErrUserDisabled
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return -1, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+		}
+		return userId, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return -1, fmt.Errorf("ошибка при поиске внешнего аккаунта: %w", err)
+	}
+
+	userId = -1
+
+	if linkByEmail && ext.EmailVerified && ext.Email != "" {
+		// Связываем только с подтвержденным адресом: иначе кто угодно мог бы
+		// заранее зарегистрировать чужой email и получить доступ к аккаунту
+		// владельца, когда тот впервые войдет через провайдера
+		const qEmail = `
+			SELECT id, disabled_at IS NOT NULL FROM users
+			WHERE lower(email) = lower($1) AND email_verified_at IS NOT NULL AND deleted_at IS NULL
+		`
+		err = tx.QueryRow(ctx, qEmail, ext.Email).Scan(&userId, &disabled)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return -1, fmt.Errorf("ошибка при поиске пользователя по email: %w", err)
+		}
+		if err == nil && disabled {
+			return -1, ErrUserDisabled
+		}
+	}
+
+	if userId == -1 {
+		if !allowSignup {
+			return -1, ErrIdentityNotLinked
+		}
+
+		userId, err = createExternalUser(ctx, tx, ext)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	if err := insertIdentity(ctx, tx, userId, ext); err != nil {
+		return -1, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return -1, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return userId, nil
+}
+
+func (repo *RepositoryPg) LinkIdentity(ctx context.Context, userId int, ext identities.External) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertIdentity(ctx, tx, userId, ext); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func insertIdentity(ctx context.Context, tx pgx.Tx, userId int, ext identities.External) error {
+	const q = `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), now())
+		ON CONFLICT (provider, subject) DO UPDATE
+		SET last_login_at = now()
+		WHERE user_identities.user_id = EXCLUDED.user_id
+	`
+
+	result, err := tx.Exec(ctx, q, userId, ext.Provider, ext.Subject, ext.Email)
+	if err != nil {
+		return fmt.Errorf("не удалось связать внешний аккаунт: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrIdentityTaken
+	}
+
+	return nil
+}
+
+// createExternalUser создает пользователя без пароля. Имя строится из
+// preferred_username или name провайдера со случайным суффиксом: email в имя
+// не попадает, иначе его было бы видно в поиске и профиле.
+func createExternalUser(ctx context.Context, tx pgx.Tx, ext identities.External) (int, error) {
+	base := externalUsernameBase(ext)
+
+	const qTaken = `SELECT EXISTS (SELECT 1 FROM users WHERE lower(username) = lower($1))`
+
+	username := ""
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return -1, fmt.Errorf("не удалось сгенерировать имя пользователя: %w", err)
+		}
+		candidate := base + "_" + hex.EncodeToString(suffix)
+
+		var taken bool
+		if err := tx.QueryRow(ctx, qTaken, candidate).Scan(&taken); err != nil {
+			return -1, fmt.Errorf("ошибка при проверке имени пользователя: %w", err)
+		}
+		if !taken {
+			username = candidate
+			break
+		}
+	}
+
+	if username == "" {
+		return -1, fmt.Errorf("не удалось подобрать свободное имя для пользователя %s:%s", ext.Provider, ext.Subject)
+	}
+
+	// Email может быть уже занят другим пользователем — тогда создаем без него
+	email := ""
+	if ext.EmailVerified && ext.Email != "" {
+		const qEmail = `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))`
+
+		var taken bool
+		if err := tx.QueryRow(ctx, qEmail, ext.Email).Scan(&taken); err != nil {
+			return -1, fmt.Errorf("ошибка при проверке email: %w", err)
+		}
+		if !taken {
+			email = ext.Email
+		}
+	}
+
+	displayName := []rune(ext.DisplayName)
+	if len(displayName) > 64 {
+		displayName = displayName[:64]
+	}
+
+	const q = `
+		INSERT INTO users (username, email, email_verified_at, display_name)
+		VALUES ($1, NULLIF($2, ''), CASE WHEN $2 = '' THEN NULL ELSE now() END, NULLIF($3, ''))
+		RETURNING id
+	`
+
+	var userId int
+	if err := tx.QueryRow(ctx, q, username, email, string(displayName)).Scan(&userId); err != nil {
+		return -1, fmt.Errorf("не удалось создать пользователя: %w", err)
+	}
+
+	if _, err := createSavedChat(ctx, tx, userId); err != nil {
+		return -1, err
+	}
+
+	return userId, nil
+}
+
+// externalUsernameBase берет основу имени из preferred_username, а если его
+// нет — из name. Часть после @ отбрасывается: некоторые провайдеры кладут
+// в preferred_username адрес почты.
+func externalUsernameBase(ext identities.External) string {
+	for _, source := range []string{ext.Username, ext.DisplayName} {
+		source, _, _ = strings.Cut(source, "@")
+
+		var b strings.Builder
+		for _, r := range strings.TrimSpace(source) {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.':
+				b.WriteRune(r)
+			case unicode.IsSpace(r):
+				b.WriteRune('_')
+			}
+		}
+
+		base := []rune(b.String())
+		if len(base) > maxUsernameBase {
+			base = base[:maxUsernameBase]
+		}
+		if len(base) > 0 {
+			return string(base)
+		}
+	}
+
+	return "user"
+}
@@ -2,9 +2,13 @@ package db
 
 import (
 	"GGChat/internal/models/chats"
+	"GGChat/internal/models/crut/sessions"
+	"GGChat/internal/models/users"
+	"GGChat/internal/service/password"
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,195 +25,1004 @@ func NewRepositoryPg(db *pgxpool.Pool) PgRepository {
 	return &RepositoryPg{db: db}
 }
 
-func (repo *RepositoryPg) UsersVerification(ctx context.Context, username, password string) (int, bool, error) {
+func (repo *RepositoryPg) UsersVerification(ctx context.Context, username, pass string) (int, bool, error) {
 	const q = `
-	SELECT id, password FROM users
+	SELECT id, COALESCE(password, '') FROM users
+	WHERE username = $1 AND deleted_at IS NULL AND disabled_at IS NULL
+	`
+
+	var storedPassword string
+	var id int
+	err := repo.db.QueryRow(ctx, q, username).Scan(&id, &storedPassword)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, false, nil
+	}
+
+	if err != nil {
+		return -1, false, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	}
+
+	// У удаленных аккаунтов пароля нет, пустая строка не должна совпадать
+	if storedPassword == "" {
+		return -1, false, nil
+	}
+
+	ok, needsRehash, err := password.Verify(pass, storedPassword)
+	if err != nil {
+		return -1, false, fmt.Errorf("ошибка при проверке пароля: %w", err)
+	}
+
+	if !ok {
+		return -1, false, nil
+	}
+
+	// Пароли, сохраненные открытым текстом, перезаписываем хэшем при первом успешном входе
+	if needsRehash {
+		hash, err := password.Hash(pass)
+		if err != nil {
+			return -1, false, err
+		}
+
+		const u = `
+		UPDATE users SET password = $1
+		WHERE id = $2 AND password = $3
+		`
+
+		if _, err := repo.db.Exec(ctx, u, hash, id, storedPassword); err != nil {
+			return -1, false, fmt.Errorf("не удалось обновить хэш пароля: %w", err)
+		}
+	}
+
+	return id, true, nil
+}
+
+func (repo *RepositoryPg) NewUser(ctx context.Context, username, pass, email string) (bool, int, error) {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return false, -1, err
+	}
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return false, -1, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+	INSERT INTO users (username, password, email)
+	VALUES ($1, $2, NULLIF($3, ''))
+	`
+
+	_, err = tx.Exec(ctx, q, username, hash, email)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return false, -1, fmt.Errorf("пользователь с таким именем уже существует: %w", err)
+	}
+
+	if err != nil {
+		return false, -1, fmt.Errorf("не удалось вставить нового пользователя: %w", err)
+	}
+
+	const b = `
+	SELECT id FROM users
 	WHERE username = $1
 	`
 
-	var storedPassword string
-	var id int
-	err := repo.db.QueryRow(ctx, q, username).Scan(&id, &storedPassword)
+	var id int
+
+	err = tx.QueryRow(ctx, b, username).Scan(&id)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, -1, nil
+	}
+
+	if err != nil {
+		return false, -1, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	}
+
+	if _, err = createSavedChat(ctx, tx, id); err != nil {
+		return false, -1, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, -1, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return true, id, nil
+}
+
+func (repo *RepositoryPg) ChangePassword(ctx context.Context, userId int, oldPassword, newPassword string) (bool, error) {
+	const q = `
+	SELECT COALESCE(password, '') FROM users
+	WHERE id = $1
+	`
+
+	var storedPassword string
+	if err := repo.db.QueryRow(ctx, q, userId).Scan(&storedPassword); err != nil {
+		return false, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	}
+
+	if storedPassword == "" {
+		return false, nil
+	}
+
+	ok, _, err := password.Verify(oldPassword, storedPassword)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при проверке пароля: %w", err)
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return false, err
+	}
+
+	const u = `
+	UPDATE users SET password = $1
+	WHERE id = $2 AND password = $3
+	`
+
+	result, err := repo.db.Exec(ctx, u, hash, userId, storedPassword)
+	if err != nil {
+		return false, fmt.Errorf("не удалось обновить пароль: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (repo *RepositoryPg) RevokeOtherSessions(ctx context.Context, userId int, keepSessionId uuid.UUID) ([]uuid.UUID, error) {
+	const q = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE user_id = $1 AND id != $2 AND revoked_at IS NULL
+		RETURNING id
+	`
+
+	rows, err := repo.db.Query(ctx, q, userId, keepSessionId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+func (repo *RepositoryPg) GetUserEmail(ctx context.Context, login string) (int, string, error) {
+	const q = `
+		SELECT id, COALESCE(email, '') FROM users
+		WHERE (username = $1 OR lower(email) = lower($1)) AND deleted_at IS NULL AND disabled_at IS NULL
+		LIMIT 1
+	`
+
+	var id int
+	var email string
+	err := repo.db.QueryRow(ctx, q, login).Scan(&id, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, "", nil
+	}
+	if err != nil {
+		return -1, "", fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	}
+
+	return id, email, nil
+}
+
+func (repo *RepositoryPg) CreatePasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Действует только последняя выданная ссылка
+	const d = `
+		UPDATE password_resets
+		SET used_at = now()
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+	if _, err = tx.Exec(ctx, d, userId); err != nil {
+		return fmt.Errorf("не удалось отозвать старые ссылки сброса: %w", err)
+	}
+
+	const q = `
+		INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`
+	if _, err = tx.Exec(ctx, q, userId, tokenHash, expiresAt); err != nil {
+		return fmt.Errorf("не удалось сохранить ссылку сброса: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) ResetPassword(ctx context.Context, tokenHash, newPassword string) (int, []uuid.UUID, error) {
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return -1, nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		UPDATE password_resets
+		SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id
+	`
+
+	var userId int
+	err = tx.QueryRow(ctx, q, tokenHash).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, nil, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return -1, nil, fmt.Errorf("ошибка при проверке ссылки сброса: %w", err)
+	}
+
+	// Ссылка пришла на почту пользователя — значит, адрес принадлежит ему
+	const u = `UPDATE users SET password = $1, email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $2`
+	if _, err = tx.Exec(ctx, u, hash, userId); err != nil {
+		return -1, nil, fmt.Errorf("не удалось обновить пароль: %w", err)
+	}
+
+	const s = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`
+	rows, err := tx.Query(ctx, s, userId)
+	if err != nil {
+		return -1, nil, fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+
+	revoked, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return -1, nil, fmt.Errorf("не удалось отозвать сессии: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return -1, nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return userId, revoked, nil
+}
+
+func (repo *RepositoryPg) GetProfile(ctx context.Context, userId int) (*users.Profile, error) {
+	const q = `
+		SELECT id, username, COALESCE(display_name, username), avatar_path IS NOT NULL,
+			COALESCE(bio, ''), COALESCE(timezone, ''), email, discoverable
+		FROM users
+		WHERE id = $1
+	`
+
+	var profile users.Profile
+	var hasAvatar bool
+	err := repo.db.QueryRow(ctx, q, userId).Scan(
+		&profile.Id,
+		&profile.Username,
+		&profile.DisplayName,
+		&hasAvatar,
+		&profile.Bio,
+		&profile.Timezone,
+		&profile.Email,
+		&profile.Discoverable,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при получении профиля: %w", err)
+	}
+
+	profile.AvatarUrl = users.AvatarUrl(profile.Id, hasAvatar)
+
+	return &profile, nil
+}
+
+func (repo *RepositoryPg) UpdateProfile(ctx context.Context, userId int, req users.UpdateProfileRequest) (*users.Profile, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// NULL в параметре означает «не менять поле», пустая строка — «очистить».
+	// Новый email считается неподтвержденным
+	const q = `
+		UPDATE users SET
+			display_name = CASE WHEN $2::text IS NULL THEN display_name ELSE NULLIF($2, '') END,
+			bio = CASE WHEN $3::text IS NULL THEN bio ELSE NULLIF($3, '') END,
+			timezone = CASE WHEN $4::text IS NULL THEN timezone ELSE NULLIF($4, '') END,
+			email = CASE WHEN $5::text IS NULL THEN email ELSE NULLIF(lower($5), '') END,
+			email_verified_at = CASE
+				WHEN $5::text IS NULL OR NULLIF(lower($5), '') IS NOT DISTINCT FROM email THEN email_verified_at
+				ELSE NULL
+			END,
+			discoverable = COALESCE($6, discoverable)
+		WHERE id = $1
+	`
+
+	result, err := tx.Exec(ctx, q, userId, req.DisplayName, req.Bio, req.Timezone, req.Email, req.Discoverable)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось обновить профиль: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	// Ссылки сброса, отправленные на прежний адрес, не должны подтвердить новый
+	if req.Email != nil {
+		const d = `DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`
+		if _, err := tx.Exec(ctx, d, userId); err != nil {
+			return nil, fmt.Errorf("не удалось отозвать ссылки сброса пароля: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return repo.GetProfile(ctx, userId)
+}
+
+func (repo *RepositoryPg) SetAvatar(ctx context.Context, userId int, path string) (string, error) {
+	const q = `
+		UPDATE users u SET avatar_path = $2
+		FROM (SELECT id, avatar_path FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING COALESCE(old.avatar_path, '')
+	`
+
+	var oldPath string
+	err := repo.db.QueryRow(ctx, q, userId, path).Scan(&oldPath)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("не удалось сохранить аватар: %w", err)
+	}
+
+	return oldPath, nil
+}
+
+func (repo *RepositoryPg) GetAvatarPath(ctx context.Context, userId int) (string, error) {
+	const q = `
+		SELECT COALESCE(avatar_path, '') FROM users
+		WHERE id = $1
+	`
+
+	var path string
+	err := repo.db.QueryRow(ctx, q, userId).Scan(&path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка при получении аватара: %w", err)
+	}
+
+	return path, nil
+}
+
+func (repo *RepositoryPg) CreateSession(ctx context.Context, userId int, refreshHash, deviceLabel, userAgent, ip string, expiresAt time.Time) (uuid.UUID, error) {
+	const q = `
+		INSERT INTO sessions (user_id, refresh_token_hash, device_label, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+
+	var sessionId uuid.UUID
+	err := repo.db.QueryRow(ctx, q, userId, refreshHash, deviceLabel, userAgent, ip, expiresAt).Scan(&sessionId)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("не удалось создать сессию: %w", err)
+	}
+
+	return sessionId, nil
+}
+
+func (repo *RepositoryPg) RotateSession(ctx context.Context, sessionId uuid.UUID, oldHash, newHash string, expiresAt time.Time) (int, error) {
+	const q = `
+		UPDATE sessions
+		SET refresh_token_hash = $3, expires_at = $4, last_seen_at = now()
+		WHERE id = $1 AND refresh_token_hash = $2
+		AND revoked_at IS NULL AND expires_at > now()
+		RETURNING user_id
+	`
+
+	var userId int
+	err := repo.db.QueryRow(ctx, q, sessionId, oldHash, newHash, expiresAt).Scan(&userId)
+	if err == nil {
+		return userId, nil
+	}
+
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return -1, fmt.Errorf("не удалось обновить сессию: %w", err)
+	}
+
+	// Сессия жива, но хэш не совпал: старый refresh-токен предъявлен повторно,
+	// значит его могли украсть. Отзываем сессию целиком.
+	const r = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+	`
+
+	result, err := repo.db.Exec(ctx, r, sessionId)
+	if err != nil {
+		return -1, fmt.Errorf("не удалось отозвать сессию: %w", err)
+	}
+
+	if result.RowsAffected() > 0 {
+		return -1, ErrRefreshTokenReused
+	}
+
+	return -1, ErrSessionNotFound
+}
+
+func (repo *RepositoryPg) RevokeSession(ctx context.Context, sessionId uuid.UUID, refreshHash string) error {
+	const q = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE id = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, sessionId, refreshHash)
+	if err != nil {
+		return fmt.Errorf("не удалось отозвать сессию: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) TouchSession(ctx context.Context, sessionId uuid.UUID) (bool, error) {
+	const q = `
+		UPDATE sessions s
+		SET last_seen_at = now()
+		FROM users u
+		WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > now()
+		AND u.id = s.user_id AND u.disabled_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, sessionId)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при проверке сессии: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (repo *RepositoryPg) GetSessions(ctx context.Context, userId int) ([]sessions.Session, error) {
+	const q = `
+		SELECT id, COALESCE(device_label, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := repo.db.Query(ctx, q, userId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить список сессий: %w", err)
+	}
+	defer rows.Close()
+
+	var result []sessions.Session
+	for rows.Next() {
+		var session sessions.Session
+		err := rows.Scan(&session.Id, &session.DeviceLabel, &session.Ip, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при сканировании сессии: %w", err)
+		}
+		result = append(result, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по результатам: %w", err)
+	}
+
+	return result, nil
+}
+
+func (repo *RepositoryPg) RevokeUserSession(ctx context.Context, userId int, sessionId uuid.UUID) error {
+	const q = `
+		UPDATE sessions
+		SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, sessionId, userId)
+	if err != nil {
+		return fmt.Errorf("не удалось отозвать сессию: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) GetTotp(ctx context.Context, userId int) (string, string, bool, error) {
+	const q = `
+		SELECT username, COALESCE(totp_secret, ''), totp_enabled
+		FROM users
+		WHERE id = $1
+	`
+
+	var username, secret string
+	var enabled bool
+	err := repo.db.QueryRow(ctx, q, userId).Scan(&username, &secret, &enabled)
+	if err != nil {
+		return "", "", false, fmt.Errorf("ошибка при получении настроек TOTP: %w", err)
+	}
+
+	return username, secret, enabled, nil
+}
+
+func (repo *RepositoryPg) SetTotpSecret(ctx context.Context, userId int, secret string) error {
+	const q = `
+		UPDATE users
+		SET totp_secret = $1, totp_enabled = false, totp_last_counter = NULL
+		WHERE id = $2 AND totp_enabled = false
+	`
+
+	result, err := repo.db.Exec(ctx, q, secret, userId)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить секрет TOTP: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("двухфакторная аутентификация уже включена")
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) EnableTotp(ctx context.Context, userId int, counter int64, recoveryHashes []string) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const q = `
+		UPDATE users
+		SET totp_enabled = true, totp_last_counter = $1
+		WHERE id = $2 AND totp_secret IS NOT NULL
+	`
+
+	result, err := tx.Exec(ctx, q, counter, userId)
+	if err != nil {
+		return fmt.Errorf("не удалось включить TOTP: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("секрет TOTP не найден")
+	}
 
-	if errors.Is(err, pgx.ErrNoRows) {
-		return -1, false, nil
+	const d = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err = tx.Exec(ctx, d, userId); err != nil {
+		return fmt.Errorf("не удалось удалить старые коды восстановления: %w", err)
 	}
 
-	if err != nil {
-		return -1, false, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+	const i = `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		VALUES ($1, $2)
+	`
+	for _, hash := range recoveryHashes {
+		if _, err = tx.Exec(ctx, i, userId, hash); err != nil {
+			return fmt.Errorf("не удалось сохранить код восстановления: %w", err)
+		}
 	}
 
-	if storedPassword != password {
-		return -1, false, nil
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
 	}
 
-	return id, true, nil
+	return nil
 }
 
-func (repo *RepositoryPg) NewUser(ctx context.Context, username, password string) (bool, int, error) {
+func (repo *RepositoryPg) DisableTotp(ctx context.Context, userId int) error {
 	tx, err := repo.db.Begin(ctx)
 	if err != nil {
-		return false, -1, fmt.Errorf("не удалось начать транзакцию: %w", err)
+		return fmt.Errorf("не удалось начать транзакцию: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
 	const q = `
-	INSERT INTO users (username, password)
-	VALUES ($1, $2)
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled = false, totp_last_counter = NULL
+		WHERE id = $1
 	`
+	if _, err = tx.Exec(ctx, q, userId); err != nil {
+		return fmt.Errorf("не удалось отключить TOTP: %w", err)
+	}
 
-	_, err = tx.Exec(ctx, q, username, password)
+	const d = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err = tx.Exec(ctx, d, userId); err != nil {
+		return fmt.Errorf("не удалось удалить коды восстановления: %w", err)
+	}
 
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-		return false, -1, fmt.Errorf("пользователь с таким именем уже существует: %w", err)
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) UseTotpCounter(ctx context.Context, userId int, counter int64) (bool, error) {
+	const q = `
+		UPDATE users
+		SET totp_last_counter = $2
+		WHERE id = $1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)
+	`
+
+	result, err := repo.db.Exec(ctx, q, userId, counter)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при сохранении шага TOTP: %w", err)
 	}
 
+	return result.RowsAffected() > 0, nil
+}
+
+func (repo *RepositoryPg) UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error) {
+	const q = `
+		UPDATE recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, userId, codeHash)
 	if err != nil {
-		return false, -1, fmt.Errorf("не удалось вставить нового пользователя: %w", err)
+		return false, fmt.Errorf("ошибка при проверке кода восстановления: %w", err)
 	}
 
-	const b = `
-	SELECT id FROM users
-	WHERE username = $1
+	return result.RowsAffected() > 0, nil
+}
+
+func (repo *RepositoryPg) CreateLoginChallenge(ctx context.Context, userId int, expiresAt time.Time) (uuid.UUID, error) {
+	const q = `
+		INSERT INTO login_challenges (user_id, expires_at)
+		VALUES ($1, $2)
+		RETURNING id
 	`
 
-	var id int
+	var challengeId uuid.UUID
+	if err := repo.db.QueryRow(ctx, q, userId, expiresAt).Scan(&challengeId); err != nil {
+		return uuid.UUID{}, fmt.Errorf("не удалось создать запрос на подтверждение входа: %w", err)
+	}
 
-	err = tx.QueryRow(ctx, b, username).Scan(&id)
+	return challengeId, nil
+}
+
+func (repo *RepositoryPg) CheckLoginChallenge(ctx context.Context, challengeId uuid.UUID, maxAttempts int) (int, error) {
+	const q = `
+		UPDATE login_challenges
+		SET attempts = attempts + 1
+		WHERE id = $1 AND used_at IS NULL AND expires_at > now() AND attempts < $2
+		RETURNING user_id
+	`
 
+	var userId int
+	err := repo.db.QueryRow(ctx, q, challengeId, maxAttempts).Scan(&userId)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return false, -1, nil
+		return -1, ErrChallengeNotFound
+	}
+	if err != nil {
+		return -1, fmt.Errorf("ошибка при проверке запроса на подтверждение входа: %w", err)
 	}
 
+	return userId, nil
+}
+
+func (repo *RepositoryPg) ConsumeLoginChallenge(ctx context.Context, challengeId uuid.UUID) error {
+	const q = `
+		UPDATE login_challenges
+		SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, challengeId)
 	if err != nil {
-		return false, -1, fmt.Errorf("ошибка при поиске данных в БД: %w", err)
+		return fmt.Errorf("ошибка при подтверждении входа: %w", err)
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return false, -1, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+	if result.RowsAffected() == 0 {
+		return ErrChallengeNotFound
 	}
 
-	return true, id, nil
+	return nil
 }
 
-func (repo *RepositoryPg) NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, error) {
-	tx, err := repo.db.Begin(ctx)
+func (repo *RepositoryPg) GetLoginAttempts(ctx context.Context, scope, key string) (int, time.Time, error) {
+	const q = `
+		SELECT failures, COALESCE(blocked_until, to_timestamp(0))
+		FROM login_attempts
+		WHERE scope = $1 AND key = $2
+	`
+
+	var failures int
+	var blockedUntil time.Time
+	err := repo.db.QueryRow(ctx, q, scope, key).Scan(&failures, &blockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
 	if err != nil {
-		return false, uuid.UUID{}, fmt.Errorf("не удалось начать транзакцию: %w", err)
+		return 0, time.Time{}, fmt.Errorf("ошибка при получении попыток входа: %w", err)
 	}
-	defer tx.Rollback(ctx)
 
-	var ChatId uuid.UUID
+	return failures, blockedUntil, nil
+}
+
+func (repo *RepositoryPg) RegisterLoginFailure(ctx context.Context, scope, key string, window time.Duration) (int, error) {
+	const q = `
+		INSERT INTO login_attempts (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, now())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < now() - make_interval(secs => $3) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = now()
+		RETURNING failures
+	`
+
+	var failures int
+	if err := repo.db.QueryRow(ctx, q, scope, key, window.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("ошибка при сохранении неудачной попытки входа: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (repo *RepositoryPg) BlockLogin(ctx context.Context, scope, key string, until time.Time) error {
+	const q = `
+		UPDATE login_attempts
+		SET blocked_until = $3
+		WHERE scope = $1 AND key = $2
+	`
+
+	if _, err := repo.db.Exec(ctx, q, scope, key, until); err != nil {
+		return fmt.Errorf("ошибка при блокировке входа: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) ResetLoginAttempts(ctx context.Context, scope, key string) error {
+	const q = `
+		DELETE FROM login_attempts
+		WHERE scope = $1 AND key = $2
+	`
+
+	if _, err := repo.db.Exec(ctx, q, scope, key); err != nil {
+		return fmt.Errorf("ошибка при сбросе попыток входа: %w", err)
+	}
+
+	return nil
+}
+
+// RegisterPasswordResetRequest учитывает запрос сброса пароля и возвращает
+// число запросов по ключу с начала текущего окна длиной window.
+func (repo *RepositoryPg) RegisterPasswordResetRequest(ctx context.Context, scope, key string, window time.Duration) (int, error) {
 	const q = `
-		INSERT INTO chats (name)
-		VALUES ($1)
-		returning uuid
+		INSERT INTO password_reset_requests (scope, key, requests, window_started_at)
+		VALUES ($1, $2, 1, now())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET requests = CASE
+				WHEN password_reset_requests.window_started_at < now() - make_interval(secs => $3) THEN 1
+				ELSE password_reset_requests.requests + 1
+			END,
+			window_started_at = CASE
+				WHEN password_reset_requests.window_started_at < now() - make_interval(secs => $3) THEN now()
+				ELSE password_reset_requests.window_started_at
+			END
+		RETURNING requests
 	`
 
-	err = tx.QueryRow(ctx, q, chatName).Scan(&ChatId)
+	var requests int
+	if err := repo.db.QueryRow(ctx, q, scope, key, window.Seconds()).Scan(&requests); err != nil {
+		return 0, fmt.Errorf("ошибка при учете запроса сброса пароля: %w", err)
+	}
+
+	return requests, nil
+}
+
+// NewChat создает личный чат двух пользователей. Такой чат у пары
+// единственный: если он уже есть, возвращается он с сохраненным названием,
+// а флаг created равен false. Оба собеседника снова становятся участниками,
+// даже если кто-то из них вышел раньше. Если один заблокировал другого,
+// возвращается ErrUserBlocked.
+func (repo *RepositoryPg) NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, string, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return false, uuid.UUID{}, "", fmt.Errorf("не удалось начать транзакцию: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	blocked, err := isBlocked(ctx, tx, UserId, other_user_id)
 	if err != nil {
-		return false, ChatId, fmt.Errorf("не удалось создать чат: %w", err)
+		return false, uuid.UUID{}, "", err
+	}
+	if blocked {
+		return false, uuid.UUID{}, "", ErrUserBlocked
 	}
 
-	const p = `
-		INSERT INTO chat_numbers (chat_id, user_id)
+	var ChatId uuid.UUID
+	var name string
+	const q = `
+		INSERT INTO chats (name, direct_key)
 		VALUES ($1, $2)
+		ON CONFLICT (direct_key) DO NOTHING
+		returning uuid, COALESCE(name, '')
 	`
 
-	_, err = tx.Exec(ctx, p, ChatId, UserId)
+	key := directKey(UserId, other_user_id)
+
+	created := true
+	err = tx.QueryRow(ctx, q, chatName, key).Scan(&ChatId, &name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		created = false
+
+		const e = `SELECT uuid, COALESCE(name, '') FROM chats WHERE direct_key = $1`
+		err = tx.QueryRow(ctx, e, key).Scan(&ChatId, &name)
+	}
 	if err != nil {
-		return false, ChatId, fmt.Errorf("не удалось создать чат: %w", err)
+		return false, ChatId, "", fmt.Errorf("не удалось создать чат: %w", err)
 	}
 
-	const b = `
-		INSERT INTO chat_numbers (chat_id, user_id)
-		VALUES ($1, $2)
+	// В личном чате оба собеседника — обычные участники: удалить чужие
+	// сообщения не может ни один из них, а удалить чат целиком у обоих —
+	// любой (см. chats.CanDeleteChat)
+	const p = `
+		INSERT INTO chat_numbers (chat_id, user_id, role)
+		SELECT $1, unnest($2::int4[]), 'member'
+		ON CONFLICT DO NOTHING
 	`
 
-	_, err = tx.Exec(ctx, b, ChatId, other_user_id)
+	_, err = tx.Exec(ctx, p, ChatId, []int{UserId, other_user_id})
 	if err != nil {
-		return false, ChatId, fmt.Errorf("не удалось создать чат: %w", err)
+		return false, ChatId, "", fmt.Errorf("не удалось создать чат: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return false, ChatId, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
+		return false, ChatId, "", fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
 	}
 
-	return true, ChatId, nil
+	return created, ChatId, name, nil
 }
 
-func (repo *RepositoryPg) DeleteChat(ctx context.Context, uuid uuid.UUID) error {
+// DeleteChat удаляет чат целиком у всех участников: группу или канал —
+// владелец, личный чат — любой из собеседников. Возвращает путь к его
+// аватару, чтобы вызывающий удалил файл, и бывших участников, чтобы
+// разослать им chat_deleted.
+func (repo *RepositoryPg) DeleteChat(ctx context.Context, uuid uuid.UUID, actorId int) (string, []int, error) {
 	tx, err := repo.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("не удалось начать транзакцию: %w", err)
+		return "", nil, fmt.Errorf("не удалось начать транзакцию: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	const r = `
-		SELECT uuid FROM chats
-		WHERE uuid = $1
-	`
+	chatType, role, err := chatRole(ctx, tx, uuid, actorId)
+	if err != nil {
+		return "", nil, err
+	}
+	if !chats.CanDeleteChat(chatType, role) {
+		return "", nil, ErrChatForbidden
+	}
 
-	result, err := tx.Exec(ctx, r, uuid)
+	avatarPath, memberIds, err := purgeChat(ctx, tx, uuid)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("чат не найден или был удален ранее")
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
 	}
+	return avatarPath, memberIds, nil
+}
 
+// purgeChat удаляет чат со всеми сообщениями и возвращает путь к его
+// аватару и оставшихся в нем участников
+func purgeChat(ctx context.Context, tx pgx.Tx, uuid uuid.UUID) (string, []int, error) {
 	const q = `
-		DELETE FROM message
-		WHERE chat_id = $1
+		WITH removed AS (
+			DELETE FROM message WHERE chat_id = $1 RETURNING id
+		), keys AS (
+			DELETE FROM message_keys WHERE message_id IN (SELECT id FROM removed)
+		), edits AS (
+			DELETE FROM message_edits WHERE message_id IN (SELECT id FROM removed)
+		)
+		DELETE FROM message_status WHERE message_id IN (SELECT id FROM removed)
 	`
 
-	_, err = tx.Exec(ctx, q, uuid)
+	_, err := tx.Exec(ctx, q, uuid)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+
+	for _, a := range []string{
+		`DELETE FROM chat_role_changes WHERE chat_id = $1`,
+		`DELETE FROM chat_invites WHERE chat_id = $1`,
+		`DELETE FROM chat_join_requests WHERE chat_id = $1`,
+	} {
+		if _, err = tx.Exec(ctx, a, uuid); err != nil {
+			return "", nil, err
+		}
 	}
 
 	const w = `
 		DELETE FROM chat_numbers
 		WHERE chat_id = $1
+		RETURNING user_id
 	`
 
-	_, err = tx.Exec(ctx, w, uuid)
+	rows, err := tx.Query(ctx, w, uuid)
+	if err != nil {
+		return "", nil, err
+	}
+	memberIds, err := pgx.CollectRows(rows, pgx.RowTo[int])
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	const e = `
 		DELETE FROM chats
 		WHERE uuid = $1
+		RETURNING COALESCE(avatar_path, '')
 	`
 
-	result, err = tx.Exec(ctx, e, uuid)
-	if err != nil {
-		return err
+	var avatarPath string
+	err = tx.QueryRow(ctx, e, uuid).Scan(&avatarPath)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, fmt.Errorf("чат не был удален")
 	}
-	rowsAffected = result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("чат не был удален")
+	if err != nil {
+		return "", nil, err
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
-	}
-	return nil
+	return avatarPath, memberIds, nil
 }
 
 // pg_repository.go
-func (repo *RepositoryPg) GetAllChats(ctx context.Context, UserId int) ([]chats.Chat, error) {
+func (repo *RepositoryPg) GetAllChats(ctx context.Context, UserId int, filter chats.ListFilter) ([]chats.Chat, error) {
 	const q = `
 		SELECT 
-			c.uuid, 
-			(select username from users
-			where id = (SELECT user_id FROM chat_numbers
-			where chat_id = c.uuid and user_id != $1)
-			) AS chat_name,
-			(
+			c.uuid,
+			c.type,
+			CASE WHEN c.type = 'direct' THEN COALESCE(other.name, '')
+				ELSE COALESCE(c.name, '') END AS chat_name,
+			c.description,
+			c.avatar_path IS NOT NULL,
+			other.id,
+			COALESCE(other.has_avatar, false),
+			CASE WHEN c.type = 'channel' THEN (
+				SELECT COUNT(*)
+				FROM message m
+				WHERE m.chat_id = c.uuid
+				AND m.id > COALESCE(cn.last_read_message_id, 0)
+				AND m.sender_id != $1
+			) ELSE (
 				SELECT COUNT(*)
 				FROM message_status ms
 				JOIN message m ON ms.message_id = m.id
@@ -217,16 +1030,30 @@ func (repo *RepositoryPg) GetAllChats(ctx context.Context, UserId int) ([]chats.
 				AND ms.user_id = $1           
 				AND ms.status != 'read'       
 				AND m.sender_id != $1         
-			) AS unread_count,
+			) END AS unread_count,
+			(SELECT COUNT(*) FROM chat_numbers WHERE chat_id = c.uuid) AS member_count,
 			lm.content AS last_message,
-			lmk.encrypted_key AS last_message_key
+			lmk.encrypted_key AS last_message_key,
+			cn.archived,
+			CASE WHEN cn.muted_until > now() THEN cn.muted_until END,
+			cn.pinned_order,
+			cn.folder_id
 		FROM chats c
 		JOIN chat_numbers cn ON c.uuid = cn.chat_id
+
+		LEFT JOIN LATERAL (
+			SELECT u.id, COALESCE(u.display_name, u.username) AS name, u.avatar_path IS NOT NULL AS has_avatar
+			FROM chat_numbers cn2
+			JOIN users u ON u.id = cn2.user_id
+			WHERE cn2.chat_id = c.uuid AND cn2.user_id != $1 AND c.type = 'direct'
+			LIMIT 1
+		) other ON true
 		
 		LEFT JOIN LATERAL (
 			SELECT m.id, m.content, m.sent_at
 			FROM message m
 			WHERE m.chat_id = c.uuid
+			AND m.id > COALESCE(cn.cleared_up_to, 0)
 			ORDER BY m.sent_at DESC
 			LIMIT 1
 		) lm ON true
@@ -238,11 +1065,12 @@ func (repo *RepositoryPg) GetAllChats(ctx context.Context, UserId int) ([]chats.
 			LIMIT 1
 		) lmk ON true
 		
-		WHERE cn.user_id = $1
-		ORDER BY lm.sent_at DESC NULLS LAST;
+		WHERE cn.user_id = $1 AND cn.archived = $2
+		AND ($3::int4 IS NULL OR cn.folder_id = $3)
+		ORDER BY cn.pinned_order ASC NULLS LAST, lm.sent_at DESC NULLS LAST;
 	`
 
-	rows, err := repo.db.Query(ctx, q, UserId)
+	rows, err := repo.db.Query(ctx, q, UserId, filter.Archived, filter.FolderId)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось получить список чатов пользователя: %w", err)
 	}
@@ -251,10 +1079,19 @@ func (repo *RepositoryPg) GetAllChats(ctx context.Context, UserId int) ([]chats.
 	var Chats []chats.Chat
 	for rows.Next() {
 		var chat chats.Chat
-		err := rows.Scan(&chat.Uuid, &chat.Name, &chat.UnreadCount, &chat.LastMessage, &chat.LastMessageKey)
+		var otherId *int
+		var chatHasAvatar, hasAvatar bool
+		err := rows.Scan(&chat.Uuid, &chat.Type, &chat.Name, &chat.Description, &chatHasAvatar, &otherId, &hasAvatar, &chat.UnreadCount, &chat.MemberCount, &chat.LastMessage, &chat.LastMessageKey,
+			&chat.Archived, &chat.MutedUntil, &chat.PinnedOrder, &chat.FolderId)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка при сканировании данных чата: %w", err)
 		}
+		// Собственный аватар чата важнее аватара собеседника
+		if chatHasAvatar {
+			chat.AvatarUrl = chats.AvatarUrl(chat.Uuid, true)
+		} else if otherId != nil {
+			chat.AvatarUrl = users.AvatarUrl(*otherId, hasAvatar)
+		}
 		Chats = append(Chats, chat)
 	}
 
@@ -269,10 +1106,13 @@ func (repo *RepositoryPg) GetUser(ctx context.Context, username string) (int, er
 	var user_id int
 	const q = `
 		SELECT id FROM users
-		WHERE username = $1
+		WHERE username = $1 AND deleted_at IS NULL
 	`
 
 	err := repo.db.QueryRow(ctx, q, username).Scan(&user_id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, ErrUserNotFound
+	}
 	if err != nil {
 		return -1, fmt.Errorf("ошибка при получение списка всех пользователей: %v", err)
 	}
@@ -280,6 +1120,121 @@ func (repo *RepositoryPg) GetUser(ctx context.Context, username string) (int, er
 	return user_id, nil
 }
 
+func (repo *RepositoryPg) SearchUsers(ctx context.Context, userId int, query string, limit, offset int) ([]users.Summary, error) {
+	const q = `
+		SELECT u.id, u.username, COALESCE(u.display_name, u.username), u.avatar_path IS NOT NULL
+		FROM users u
+		WHERE u.id != $1
+		AND u.discoverable
+		AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = $1 AND b.blocked_id = u.id)
+			OR (b.blocker_id = u.id AND b.blocked_id = $1)
+		)
+		AND (
+			u.username ILIKE $3 OR u.display_name ILIKE $3
+			OR u.username % $2 OR u.display_name % $2
+		)
+		ORDER BY
+			(u.username ILIKE $3 OR u.display_name ILIKE $3) DESC,
+			GREATEST(similarity(u.username, $2), similarity(COALESCE(u.display_name, ''), $2)) DESC,
+			u.username
+		LIMIT $4 OFFSET $5
+	`
+
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	rows, err := repo.db.Query(ctx, q, userId, query, prefix, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при поиске пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	result := []users.Summary{}
+	for rows.Next() {
+		var user users.Summary
+		var hasAvatar bool
+		if err := rows.Scan(&user.Id, &user.Username, &user.DisplayName, &hasAvatar); err != nil {
+			return nil, fmt.Errorf("ошибка при сканировании пользователя: %w", err)
+		}
+		user.AvatarUrl = users.AvatarUrl(user.Id, hasAvatar)
+		result = append(result, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка при итерации по результатам: %w", err)
+	}
+
+	return result, nil
+}
+
+func (repo *RepositoryPg) BlockUser(ctx context.Context, blockerId, blockedId int) error {
+	const q = `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		SELECT $1, id FROM users WHERE id = $2 AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING
+	`
+
+	result, err := repo.db.Exec(ctx, q, blockerId, blockedId)
+	if err != nil {
+		return fmt.Errorf("не удалось заблокировать пользователя: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		var exists bool
+		const e = `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`
+		if err := repo.db.QueryRow(ctx, e, blockedId).Scan(&exists); err != nil {
+			return fmt.Errorf("ошибка при поиске пользователя: %w", err)
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+	}
+
+	return nil
+}
+
+func (repo *RepositoryPg) UnblockUser(ctx context.Context, blockerId, blockedId int) error {
+	const q = `
+		DELETE FROM user_blocks
+		WHERE blocker_id = $1 AND blocked_id = $2
+	`
+
+	if _, err := repo.db.Exec(ctx, q, blockerId, blockedId); err != nil {
+		return fmt.Errorf("не удалось разблокировать пользователя: %w", err)
+	}
+
+	return nil
+}
+
+// qBlocked проверяет блокировку в любую сторону
+const qBlocked = `
+	SELECT EXISTS (
+		SELECT 1 FROM user_blocks
+		WHERE (blocker_id = $1 AND blocked_id = $2)
+		OR (blocker_id = $2 AND blocked_id = $1)
+	)
+`
+
+func (repo *RepositoryPg) IsBlocked(ctx context.Context, userId, otherUserId int) (bool, error) {
+	var blocked bool
+	if err := repo.db.QueryRow(ctx, qBlocked, userId, otherUserId).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("ошибка при проверке блокировки: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// isBlocked — та же проверка внутри транзакции, меняющей состав чата
+func isBlocked(ctx context.Context, tx pgx.Tx, userId, otherUserId int) (bool, error) {
+	var blocked bool
+	if err := tx.QueryRow(ctx, qBlocked, userId, otherUserId).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("ошибка при проверке блокировки: %w", err)
+	}
+
+	return blocked, nil
+}
+
 func (repo *RepositoryPg) NewMessage(ctx context.Context, chatId uuid.UUID, senderId int, encryptedContent string, encryptedKeys map[int]string) (int, string, error) {
 	tx, err := repo.db.Begin(ctx)
 	if err != nil {
@@ -287,6 +1242,29 @@ func (repo *RepositoryPg) NewMessage(ctx context.Context, chatId uuid.UUID, send
 	}
 	defer tx.Rollback(ctx)
 
+	const qSender = `
+		SELECT c.type, cn.role
+		FROM chats c
+		JOIN chat_numbers cn ON cn.chat_id = c.uuid AND cn.user_id = $2
+		WHERE c.uuid = $1
+	`
+	var chatType, role string
+	err = tx.QueryRow(ctx, qSender, chatId, senderId).Scan(&chatType, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, "", ErrChatNotFound
+	}
+	if err != nil {
+		return -1, "", fmt.Errorf("ошибка при проверке отправителя: %w", err)
+	}
+	if !chats.CanPost(chatType, role) {
+		return -1, "", ErrChatForbidden
+	}
+	// Каналы не шифруются: content хранится открытым текстом, и ключи,
+	// присланные клиентом, означали бы, что он считает сообщение защищенным
+	if chatType == chats.TypeChannel && len(encryptedKeys) > 0 {
+		return -1, "", ErrChannelKeys
+	}
+
 	const q = `
 		INSERT INTO message (chat_id, sender_id, content, sent_at)
 		VALUES ($1, $2, $3, NOW())
@@ -298,15 +1276,23 @@ func (repo *RepositoryPg) NewMessage(ctx context.Context, chatId uuid.UUID, send
 		return -1, "", fmt.Errorf("ошибка при отправке сообщения: %v", err)
 	}
 
-	const qKey = `
-        INSERT INTO message_keys (message_id, user_id, encrypted_key)
-        VALUES ($1, $2, $3)
-    `
+	// В канале может быть много подписчиков, поэтому ни статусов на каждого
+	// из них, ни ключей не пишем: прочитанное считается по отметке
+	// last_read_message_id
+	if chatType == chats.TypeChannel {
+		const qRead = `
+			UPDATE chat_numbers SET last_read_message_id = $3
+			WHERE chat_id = $1 AND user_id = $2
+		`
+		if _, err = tx.Exec(ctx, qRead, chatId, senderId, messageId); err != nil {
+			return -1, "", fmt.Errorf("ошибка при обновлении отметки прочтения: %w", err)
+		}
 
-	for userId, encKey := range encryptedKeys {
-		if _, err := tx.Exec(ctx, qKey, messageId, userId, encKey); err != nil {
-			return -1, "", err
+		if err := tx.Commit(ctx); err != nil {
+			return -1, "", fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
 		}
+
+		return messageId, "read", nil
 	}
 
 	const qMembers = `
@@ -334,6 +1320,23 @@ func (repo *RepositoryPg) NewMessage(ctx context.Context, chatId uuid.UUID, send
 		return -1, "", fmt.Errorf("ошибка при итерации по участникам: %v", err)
 	}
 
+	const qKey = `
+        INSERT INTO message_keys (message_id, user_id, encrypted_key)
+        VALUES ($1, $2, $3)
+    `
+
+	// Ключи сохраняем только для текущих участников: клиент с устаревшим
+	// списком не должен выдать ключ исключенному из чата пользователю
+	for _, userId := range memberIds {
+		encKey, ok := encryptedKeys[userId]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(ctx, qKey, messageId, userId, encKey); err != nil {
+			return -1, "", err
+		}
+	}
+
 	const qStatus = `
 		INSERT INTO message_status (message_id, user_id, status)
 		VALUES ($1, $2, $3)
@@ -368,12 +1371,24 @@ func (repo *RepositoryPg) GetMessage(ctx context.Context, chatId uuid.UUID, curr
 	defer tx.Rollback(ctx)
 
 	const q = `
-        SELECT m.id, m.sender_id, m.content, ms.status, m.sent_at,
-               COALESCE(mk.encrypted_key, '') as encrypted_key
+        SELECT m.id, m.sender_id, m.content,
+               COALESCE(ms.status, CASE
+                   WHEN m.sender_id = $2 OR m.id <= cn.last_read_message_id THEN 'read'
+                   ELSE 'delivered'
+               END) as status,
+               m.sent_at, m.edited_at,
+               COALESCE(mk.encrypted_key, '') as encrypted_key,
+               COALESCE(u.display_name, u.username, '') as sender_name,
+               COALESCE(u.avatar_path IS NOT NULL, false) as sender_has_avatar
         FROM message m
-        JOIN message_status ms ON m.id = ms.message_id
+        JOIN chats c ON c.uuid = m.chat_id
+        JOIN chat_numbers cn ON cn.chat_id = m.chat_id AND cn.user_id = $2
+        LEFT JOIN message_status ms ON m.id = ms.message_id AND ms.user_id = $2
+        LEFT JOIN users u ON u.id = m.sender_id
         LEFT JOIN message_keys mk ON m.id = mk.message_id AND mk.user_id = $2
-        WHERE m.chat_id = $1 AND ms.user_id = $2
+        WHERE m.chat_id = $1
+        AND m.id > COALESCE(cn.cleared_up_to, 0)
+        AND (ms.user_id IS NOT NULL OR c.type = 'channel')
         ORDER BY m.id ASC
     `
 
@@ -386,17 +1401,22 @@ func (repo *RepositoryPg) GetMessage(ctx context.Context, chatId uuid.UUID, curr
 	var result []chats.Message
 	for rows.Next() {
 		var message chats.Message
+		var senderHasAvatar bool
 		err := rows.Scan(
 			&message.MessageId,
 			&message.UserId,
 			&message.Content,
 			&message.Status,
 			&message.Time,
+			&message.EditedAt,
 			&message.EncryptedKey,
+			&message.SenderName,
+			&senderHasAvatar,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка при сканировании: %v", err)
 		}
+		message.SenderAvatarUrl = users.AvatarUrl(message.UserId, senderHasAvatar)
 		result = append(result, message)
 	}
 
@@ -415,6 +1435,19 @@ func (repo *RepositoryPg) GetMessage(ctx context.Context, chatId uuid.UUID, curr
 		return nil, fmt.Errorf("ошибка при установке статуса 'read': %v", err)
 	}
 
+	if len(result) > 0 {
+		const qRead = `
+			UPDATE chat_numbers
+			SET last_read_message_id = GREATEST(COALESCE(last_read_message_id, 0), $3)
+			WHERE chat_id = $1 AND user_id = $2
+		`
+
+		_, err = tx.Exec(ctx, qRead, chatId, currentUserId, result[len(result)-1].MessageId)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при обновлении отметки прочтения: %w", err)
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
 	}
@@ -422,27 +1455,24 @@ func (repo *RepositoryPg) GetMessage(ctx context.Context, chatId uuid.UUID, curr
 	return result, nil
 }
 
-func (repo *RepositoryPg) UpdateMessageStatus(ctx context.Context, messageId int, status string) error {
-	tx, err := repo.db.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("не удалось начать транзакцию: %w", err)
-	}
-	defer tx.Rollback(ctx)
-
+// UpdateMessageStatus меняет статус сообщения для одного получателя.
+// Сообщение ищется только в указанном чате; false означает, что менять
+// было нечего.
+func (repo *RepositoryPg) UpdateMessageStatus(ctx context.Context, chatId uuid.UUID, messageId, userId int, status string) (bool, error) {
 	const q = `
-		UPDATE message_status
-		SET status = $1
-		WHERE message_id = $2 AND status != 'read'
+		UPDATE message_status ms
+		SET status = $1, updated_at = now()
+		FROM message m
+		WHERE ms.message_id = $2 AND ms.user_id = $4 AND ms.status != 'read'
+		AND m.id = ms.message_id AND m.chat_id = $3
 	`
-	_, err = tx.Exec(ctx, q, status, messageId)
+
+	result, err := repo.db.Exec(ctx, q, status, messageId, chatId, userId)
 	if err != nil {
-		return fmt.Errorf("ошибка при обновлении статуса сообщения: %w", err)
+		return false, fmt.Errorf("ошибка при обновлении статуса сообщения: %w", err)
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("не удалось закоммитить транзакцию: %w", err)
-	}
-	return nil
+	return result.RowsAffected() > 0, nil
 }
 
 func (repo *RepositoryPg) AddPublicKey(ctx context.Context, userId int, publicKey string) error {
@@ -586,9 +1616,14 @@ func (repo *RepositoryPg) GetMessageInChat(ctx context.Context, ChatID int, Limi
 func (repo *RepositoryPg) AddPathDoc(ctx context.Context, ChatId int, Path, Name string) error {
 	fmt.Println("ПРОХОД", ChatId)
 	const q = `
-		UPDATE ai_chats
-		SET donedocpath = $1, finalfilename = $2
-		WHERE id = $3
+		WITH updated AS (
+			UPDATE ai_chats
+			SET donedocpath = $1, finalfilename = $2
+			WHERE id = $3
+			RETURNING id, user_id
+		)
+		INSERT INTO document_generations (user_id, ai_chat_id)
+		SELECT user_id, id FROM updated
 	`
 	_, err := repo.db.Exec(ctx, q, Path, Name, ChatId)
 	return err
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testRepo подключается к базе из GGCHAT_TEST_DATABASE_URL с примененной
+// migrations/init.sql. Без переменной тесты с базой пропускаются
+func testRepo(t *testing.T) PgRepository {
+	connString := os.Getenv("GGCHAT_TEST_DATABASE_URL")
+	if connString == "" {
+		t.Skip("GGCHAT_TEST_DATABASE_URL не задан")
+	}
+
+	pool, err := pgxpool.New(context.Background(), connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return NewRepositoryPg(pool)
+}
+
+func testUser(t *testing.T, repo PgRepository) int {
+	username := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, id, err := repo.NewUser(context.Background(), username, "password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	userId := testUser(t, repo)
+
+	if err := repo.SetTotpSecret(ctx, userId, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.EnableTotp(ctx, userId, 100, []string{"hash-a", "hash-b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		ok   bool
+	}{
+		{"первое использование", "hash-a", true},
+		{"повторное использование", "hash-a", false},
+		{"другой код", "hash-b", true},
+		{"неизвестный код", "hash-c", false},
+	}
+
+	for _, tt := range tests {
+		ok, err := repo.UseRecoveryCode(ctx, userId, tt.hash)
+		if err != nil || ok != tt.ok {
+			t.Errorf("%s: UseRecoveryCode = %v, %v", tt.name, ok, err)
+		}
+	}
+}
+
+func TestTotpCounterReplay(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	userId := testUser(t, repo)
+
+	if err := repo.SetTotpSecret(ctx, userId, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	// Код, которым подтвердили включение, повторно не принимается
+	if err := repo.EnableTotp(ctx, userId, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		counter int64
+		ok      bool
+	}{{100, false}, {99, false}, {101, true}, {101, false}, {103, true}} {
+		ok, err := repo.UseTotpCounter(ctx, userId, tt.counter)
+		if err != nil || ok != tt.ok {
+			t.Errorf("шаг %d: UseTotpCounter = %v, %v", tt.counter, ok, err)
+		}
+	}
+}
+
+func TestPasswordResetRequestsSeparateFromLogin(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("test_%d@example.com", time.Now().UnixNano())
+
+	for i := 1; i <= 3; i++ {
+		requests, err := repo.RegisterPasswordResetRequest(ctx, "email", key, time.Hour)
+		if err != nil || requests != i {
+			t.Fatalf("запрос %d: RegisterPasswordResetRequest = %d, %v", i, requests, err)
+		}
+	}
+
+	failures, _, err := repo.GetLoginAttempts(ctx, "email", key)
+	if err != nil || failures != 0 {
+		t.Errorf("запросы сброса попали в login_attempts: %d, %v", failures, err)
+	}
+}
+
+func TestDeleteDirectChat(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	alice, bob := testUser(t, repo), testUser(t, repo)
+
+	_, chatId, _, err := repo.NewChat(ctx, "", alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Собеседник, не создававший чат, тоже может удалить его у обоих
+	_, memberIds, err := repo.DeleteChat(ctx, chatId, bob)
+	if err != nil {
+		t.Fatalf("DeleteChat: %v", err)
+	}
+	if len(memberIds) != 2 {
+		t.Errorf("бывшие участники: %v", memberIds)
+	}
+
+	for _, userId := range []int{alice, bob} {
+		if member, err := repo.IsMember(ctx, chatId, userId); err != nil || member {
+			t.Errorf("пользователь №%d остался в чате: %v, %v", userId, member, err)
+		}
+	}
+}
+
+func TestNewChatReusesStoredName(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	alice, bob := testUser(t, repo), testUser(t, repo)
+
+	created, chatId, name, err := repo.NewChat(ctx, "первое", alice, bob)
+	if err != nil || !created || name != "первое" {
+		t.Fatalf("NewChat = %v, %q, %v", created, name, err)
+	}
+
+	created, again, name, err := repo.NewChat(ctx, "второе", bob, alice)
+	if err != nil || created || again != chatId || name != "первое" {
+		t.Errorf("повторный NewChat = %v, %s, %q, %v", created, again, name, err)
+	}
+}
+
+func TestChannelMessageKeysRejected(t *testing.T) {
+	repo := testRepo(t)
+	ctx := context.Background()
+	owner, subscriber := testUser(t, repo), testUser(t, repo)
+
+	chatId, err := repo.NewGroupChat(ctx, "анонсы", "channel", owner, []int{subscriber})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.NewMessage(ctx, chatId, owner, "шифр", map[int]string{subscriber: "ключ"}); !errors.Is(err, ErrChannelKeys) {
+		t.Errorf("сообщение с ключами: ошибка %v, ожидалась ErrChannelKeys", err)
+	}
+
+	messageId, _, err := repo.NewMessage(ctx, chatId, owner, "открытый текст", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.EditMessage(ctx, chatId, messageId, owner, "шифр", map[int]string{subscriber: "ключ"}, 0); !errors.Is(err, ErrChannelKeys) {
+		t.Errorf("правка с ключами: ошибка %v, ожидалась ErrChannelKeys", err)
+	}
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"GGChat/internal/models/crut/tokens"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (repo *RepositoryPg) CreateAccessToken(ctx context.Context, userId int, name string, scopes []string, tokenHash string, expiresAt *time.Time) (*tokens.AccessToken, error) {
+	const q = `
+		INSERT INTO access_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, name, scopes, created_at, last_used_at, expires_at
+	`
+
+	var token tokens.AccessToken
+	err := repo.db.QueryRow(ctx, q, userId, name, tokenHash, scopes, expiresAt).Scan(
+		&token.Id,
+		&token.Name,
+		&token.Scopes,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать токен доступа: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (repo *RepositoryPg) GetAccessTokens(ctx context.Context, userId int) ([]tokens.AccessToken, error) {
+	const q = `
+		SELECT id, name, scopes, created_at, last_used_at, expires_at
+		FROM access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY created_at DESC
+	`
+
+	rows, err := repo.db.Query(ctx, q, userId)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить список токенов: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (tokens.AccessToken, error) {
+		var token tokens.AccessToken
+		err := row.Scan(&token.Id, &token.Name, &token.Scopes, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+		return token, err
+	})
+}
+
+func (repo *RepositoryPg) RevokeAccessToken(ctx context.Context, userId, tokenId int) error {
+	const q = `
+		UPDATE access_tokens
+		SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := repo.db.Exec(ctx, q, tokenId, userId)
+	if err != nil {
+		return fmt.Errorf("не удалось отозвать токен: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// CheckAccessToken ищет действующий токен по хэшу и отмечает время его использования.
+// Возвращает владельца, номер токена и его права
+func (repo *RepositoryPg) CheckAccessToken(ctx context.Context, tokenHash string) (int, int, []string, error) {
+	const q = `
+		UPDATE access_tokens t
+		SET last_used_at = now()
+		FROM users u
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL
+		AND (t.expires_at IS NULL OR t.expires_at > now())
+		AND u.id = t.user_id AND u.deleted_at IS NULL AND u.disabled_at IS NULL
+		RETURNING t.user_id, t.id, t.scopes
+	`
+
+	var userId, tokenId int
+	var scopes []string
+	err := repo.db.QueryRow(ctx, q, tokenHash).Scan(&userId, &tokenId, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, -1, nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return -1, -1, nil, fmt.Errorf("ошибка при проверке токена: %w", err)
+	}
+
+	return userId, tokenId, scopes, nil
+}
+
+func (repo *RepositoryPg) CreateWsTicket(ctx context.Context, ticketHash string, ticket tokens.WsTicket, expiresAt time.Time) error {
+	const q = `
+		INSERT INTO ws_tickets (ticket_hash, user_id, session_id, token_id, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	if _, err := repo.db.Exec(ctx, q, ticketHash, ticket.UserId, ticket.SessionId, ticket.TokenId, ticket.Scopes, expiresAt); err != nil {
+		return fmt.Errorf("не удалось создать билет WebSocket: %w", err)
+	}
+
+	const d = `DELETE FROM ws_tickets WHERE expires_at < now()`
+	if _, err := repo.db.Exec(ctx, d); err != nil {
+		return fmt.Errorf("не удалось удалить просроченные билеты: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeWsTicket удаляет билет и возвращает его — повторно билет не принимается
+func (repo *RepositoryPg) ConsumeWsTicket(ctx context.Context, ticketHash string) (*tokens.WsTicket, error) {
+	const q = `
+		DELETE FROM ws_tickets
+		WHERE ticket_hash = $1 AND expires_at > now()
+		RETURNING user_id, session_id, token_id, scopes
+	`
+
+	var ticket tokens.WsTicket
+	err := repo.db.QueryRow(ctx, q, ticketHash).Scan(&ticket.UserId, &ticket.SessionId, &ticket.TokenId, &ticket.Scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTicketInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при проверке билета WebSocket: %w", err)
+	}
+
+	return &ticket, nil
+}
@@ -1,6 +1,10 @@
 package interfaces
 
+import "github.com/google/uuid"
+
 // JwtInterface определяет интерфейс для работы с JWT
 type JwtInterface interface {
-	NewToken(UserId int) (string, error)
+	NewToken(UserId int, SessionId uuid.UUID) (string, error)
+	// ParseToken проверяет подпись и срок токена и возвращает его владельца и сессию
+	ParseToken(tokenString string) (int, uuid.UUID, error)
 }
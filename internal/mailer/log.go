@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogMailer не отправляет письма, а дописывает их в файл (или в лог, если путь не задан).
+// Используется локально и в тестах.
+type LogMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+func (m *LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	if m.path == "" {
+		logrus.Infof("Письмо для %s: %s\n%s", to, subject, body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл писем: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n---\n\n",
+		time.Now().Format(time.RFC3339), to, subject, body)
+	if err != nil {
+		return fmt.Errorf("не удалось записать письмо: %w", err)
+	}
+
+	return nil
+}
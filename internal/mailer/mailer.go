@@ -0,0 +1,21 @@
+package mailer
+
+import (
+	"GGChat/internal/config"
+	"context"
+)
+
+// Mailer отправляет письма пользователям
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// New выбирает реализацию по настройке driver
+func New(cfg config.Mail) Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg)
+	default:
+		return NewLogMailer(cfg.LogPath)
+	}
+}
@@ -0,0 +1,178 @@
+package mailer
+
+import (
+	"GGChat/internal/config"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// smtpSession — то, что фейковый сервер получил от клиента
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP принимает одно соединение на локальном порту и записывает
+// команды клиента. STARTTLS не объявляется, AUTH PLAIN принимается любой.
+func fakeSMTP(t *testing.T) (int, <-chan smtpSession) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var s smtpSession
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd, arg, _ := strings.Cut(line, " ")
+
+			switch strings.ToUpper(cmd) {
+			case "EHLO":
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = strings.TrimPrefix(arg, "PLAIN ")
+				tp.PrintfLine("235 ok")
+			case "MAIL":
+				s.from = arg
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				s.to = append(s.to, arg)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				s.data = string(data)
+				tp.PrintfLine("250 ok")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				done <- s
+				return
+			default:
+				tp.PrintfLine("502 unknown")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, done
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	port, done := fakeSMTP(t)
+	m := NewSMTPMailer(config.Mail{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "bot",
+		Password: "secret",
+		From:     "GGChat <noreply@example.com>",
+	})
+
+	err := m.Send(context.Background(), "alice@example.com", "Сброс пароля", "Ссылка:\nhttps://example.com/reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-done
+
+	if auth, _ := base64.StdEncoding.DecodeString(s.auth); string(auth) != "\x00bot\x00secret" {
+		t.Errorf("AUTH PLAIN: %q", auth)
+	}
+	if s.from != "FROM:<noreply@example.com>" {
+		t.Errorf("MAIL %s", s.from)
+	}
+	if len(s.to) != 1 || s.to[0] != "TO:<alice@example.com>" {
+		t.Errorf("RCPT %v", s.to)
+	}
+
+	header, body, _ := strings.Cut(s.data, "\n\n")
+	headers := map[string]string{}
+	for _, line := range strings.Split(header, "\n") {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+
+	subject := "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte("Сброс пароля")) + "?="
+	want := map[string]string{
+		"From":         "GGChat <noreply@example.com>",
+		"To":           "alice@example.com",
+		"Subject":      subject,
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=UTF-8",
+	}
+	for k, v := range want {
+		if headers[k] != v {
+			t.Errorf("заголовок %s: %q, ожидалось %q", k, headers[k], v)
+		}
+	}
+	if headers["Date"] == "" {
+		t.Error("нет заголовка Date")
+	}
+	// ReadDotBytes переводит CRLF в LF
+	if body != "Ссылка:\nhttps://example.com/reset\n" {
+		t.Errorf("тело письма: %q", body)
+	}
+}
+
+func TestSMTPMailerBadFrom(t *testing.T) {
+	m := NewSMTPMailer(config.Mail{Host: "127.0.0.1", Port: 1, From: "не адрес"})
+	if err := m.Send(context.Background(), "alice@example.com", "s", "b"); err == nil {
+		t.Error("письмо с неверным отправителем отправлено")
+	}
+}
+
+func TestLogMailerSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewLogMailer(path)
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := m.Send(context.Background(), to, "Подтверждение", "Код: 123456"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"To: alice@example.com\nSubject: Подтверждение\n\nКод: 123456\n\n---",
+		"To: bob@example.com\nSubject: Подтверждение\n\nКод: 123456\n\n---",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("в файле нет письма:\n%s\nфайл:\n%s", want, got)
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("права файла писем: %v", info.Mode().Perm())
+	}
+}
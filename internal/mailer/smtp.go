@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"GGChat/internal/config"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPMailer struct {
+	cfg config.Mail
+}
+
+func NewSMTPMailer(cfg config.Mail) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return fmt.Errorf("неверный адрес отправителя: %w", err)
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("не удалось подключиться к SMTP-серверу: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("ошибка SMTP: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return fmt.Errorf("ошибка STARTTLS: %w", err)
+		}
+	}
+
+	if m.cfg.Username != "" {
+		auth := smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+		if err = client.Auth(auth); err != nil {
+			return fmt.Errorf("ошибка авторизации SMTP: %w", err)
+		}
+	}
+
+	if err = client.Mail(from.Address); err != nil {
+		return fmt.Errorf("ошибка SMTP MAIL FROM: %w", err)
+	}
+	if err = client.Rcpt(to); err != nil {
+		return fmt.Errorf("ошибка SMTP RCPT TO: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("ошибка SMTP DATA: %w", err)
+	}
+
+	if _, err = w.Write(buildMessage(m.cfg.From, to, subject, body)); err != nil {
+		w.Close()
+		return fmt.Errorf("ошибка отправки письма: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("ошибка отправки письма: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func buildMessage(from, to, subject, body string) []byte {
+	var msg strings.Builder
+
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + mime(subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(msg.String())
+}
+
+func mime(s string) string {
+	return "=?UTF-8?B?" + base64.StdEncoding.EncodeToString([]byte(s)) + "?="
+}
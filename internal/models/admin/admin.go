@@ -0,0 +1,56 @@
+package admin
+
+import "time"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Rank возвращает уровень роли; для неизвестной роли — 0
+func Rank(role string) int {
+	return roleRank[role]
+}
+
+type User struct {
+	Id          int        `json:"id"`
+	Username    string     `json:"username"`
+	DisplayName string     `json:"display_name"`
+	Email       *string    `json:"email"`
+	Role        string     `json:"role"`
+	DisabledAt  *time.Time `json:"disabled_at"`
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+}
+
+type UsersResponse struct {
+	Users  []User `json:"users"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+type Stats struct {
+	Users           int        `json:"users"`
+	DisabledUsers   int        `json:"disabled_users"`
+	ActiveSessions  int        `json:"active_sessions"`
+	Chats           int        `json:"chats"`
+	Messages        int        `json:"messages"`
+	AiChats         int        `json:"ai_chats"`
+	DocumentsPerDay []DayCount `json:"documents_per_day"`
+}
+
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
@@ -1,14 +1,68 @@
 package chats
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	TypeDirect = "direct"
+	TypeGroup  = "group"
+	// TypeChannel — канал: публикуют владельцы и администраторы,
+	// остальные участники только читают. Сквозного шифрования в канале
+	// нет: content хранится открытым текстом, сообщения с keys отклоняются
+	TypeChannel = "channel"
+	// TypeSaved — личный чат пользователя с самим собой: заметки и
+	// пересланные сообщения
+	TypeSaved = "saved"
+
+	SavedChatName = "Избранное"
+
+	// MaxGroupMembers ограничивает состав группового чата. Число подписчиков
+	// канала не ограничено
+	MaxGroupMembers = 200
+)
+
+// IsShared сообщает, есть ли у чата управляемый состав участников:
+// в него можно добавлять, приглашать и из него исключать
+func IsShared(chatType string) bool {
+	return chatType == TypeGroup || chatType == TypeChannel
+}
+
+// NewChatRequest создает личный чат с UserName или, если передан
+// список UserNames, групповой чат с этими участниками. С Type = channel
+// создается канал, а UserNames становятся его первыми подписчиками.
+// Сообщения канала не шифруются и хранятся на сервере открытым текстом.
 type NewChatRequest struct {
-	ChatName string `json:"chat_name"`
-	UserName string `json:"user_name"`
+	ChatName  string   `json:"chat_name"`
+	Type      string   `json:"type"`
+	UserName  string   `json:"user_name"`
+	UserNames []string `json:"user_names"`
+}
+
+type MembersRequest struct {
+	UserNames []string `json:"user_names"`
+}
+
+// Member — участник чата. PublicKey нужен клиентам, чтобы шифровать
+// ключи следующих сообщений и для нового участника.
+type Member struct {
+	UserId    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	AvatarUrl *string   `json:"avatar_url"`
+	PublicKey *string   `json:"public_key"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// MembersEvent — содержимое WebSocket-событий chat_created, member_added,
+// member_joined и member_removed
+type MembersEvent struct {
+	ActorId int      `json:"actor_id"`
+	UserIds []int    `json:"user_ids"`
+	Members []Member `json:"members,omitempty"`
 }
 
 type Response struct {
@@ -27,18 +81,74 @@ type GetMessageRequest struct {
 }
 
 type Chat struct {
-	Uuid           uuid.UUID `json:"uuid"`
-	Name           string    `json:"name"`
-	LastMessage    *string   `json:"last_message"`
-	LastMessageKey *string   `json:"last_message_key"`
-	UnreadCount    int       `json:"unread_count"`
+	Uuid           uuid.UUID  `json:"uuid"`
+	Type           string     `json:"type"`
+	Name           string     `json:"name"`
+	Description    *string    `json:"description"`
+	AvatarUrl      *string    `json:"avatar_url"`
+	LastMessage    *string    `json:"last_message"`
+	LastMessageKey *string    `json:"last_message_key"`
+	UnreadCount    int        `json:"unread_count"`
+	MemberCount    int        `json:"member_count"`
+	Archived       bool       `json:"archived"`
+	MutedUntil     *time.Time `json:"muted_until"`
+	PinnedOrder    *int       `json:"pinned_order"`
+	FolderId       *int       `json:"folder_id"`
 }
 
 type Message struct {
-	MessageId    int       `json:"message_id"`
-	UserId       int       `json:"user_id"`
+	MessageId       int       `json:"message_id"`
+	UserId          int       `json:"user_id"`
+	SenderName      string    `json:"sender_name"`
+	SenderAvatarUrl *string   `json:"sender_avatar_url"`
+	Content         string    `json:"content"`
+	EncryptedKey    string    `json:"encrypted_key"`
+	Status          string    `json:"status"`
+	Time            time.Time `json:"time"`
+	// EditedAt — время последнего редактирования или nil, если сообщение
+	// не менялось
+	EditedAt *time.Time `json:"edited_at"`
+}
+
+// EditMessageRequest заменяет зашифрованный текст сообщения. Ключи, как и
+// при отправке, шифруются заново для каждого участника. В канале текст
+// передается открытым и без ключей.
+type EditMessageRequest struct {
+	Content string         `json:"content"`
+	Keys    map[int]string `json:"keys"`
+}
+
+// MessageEdit — прежняя версия сообщения. EncryptedKey — ключ этой версии
+// для запросившего пользователя.
+type MessageEdit struct {
 	Content      string    `json:"content"`
-	EncryptedKey string    `json:"encrypted_key"`
-	Status       string    `json:"status"`
-	Time         time.Time `json:"time"`
+	EncryptedKey *string   `json:"encrypted_key"`
+	ReplacedAt   time.Time `json:"replaced_at"`
+}
+
+// UpdateChatRequest — частичное обновление: незаданные поля не меняются.
+// Пустое имя личного чата возвращает отображение имени собеседника.
+type UpdateChatRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// ChatInfo — метаданные чата, общие для всех участников. Рассылается
+// в событии chat_updated.
+type ChatInfo struct {
+	Uuid        uuid.UUID `json:"uuid"`
+	Type        string    `json:"type"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	AvatarUrl   *string   `json:"avatar_url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AvatarUrl возвращает ссылку на аватар чата или nil, если он не загружен
+func AvatarUrl(chatId uuid.UUID, hasAvatar bool) *string {
+	if !hasAvatar {
+		return nil
+	}
+	url := fmt.Sprintf("/api/v1/chats/%s/avatar", chatId)
+	return &url
 }
@@ -0,0 +1,58 @@
+package chats
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JoinPending  = "pending"
+	JoinApproved = "approved"
+	JoinRejected = "rejected"
+)
+
+type InviteRequest struct {
+	ExpiresInHours   int  `json:"expires_in_hours,omitempty"`
+	MaxUses          *int `json:"max_uses,omitempty"`
+	RequiresApproval bool `json:"requires_approval"`
+}
+
+type Invite struct {
+	Id               int        `json:"id"`
+	CreatedBy        int        `json:"created_by"`
+	RequiresApproval bool       `json:"requires_approval"`
+	MaxUses          *int       `json:"max_uses"`
+	Uses             int        `json:"uses"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// InviteResponse содержит сам токен приглашения — он показывается только один раз
+type InviteResponse struct {
+	Invite
+	Token string `json:"token"`
+}
+
+// InvitePreview — то, что видит пользователь по ссылке до вступления
+type InvitePreview struct {
+	ChatId           uuid.UUID `json:"chat_id"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	MemberCount      int       `json:"member_count"`
+	RequiresApproval bool      `json:"requires_approval"`
+	IsMember         bool      `json:"is_member"`
+}
+
+type JoinResponse struct {
+	ChatId uuid.UUID `json:"chat_id"`
+	Status string    `json:"status"`
+}
+
+type JoinRequest struct {
+	UserId    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	AvatarUrl *string   `json:"avatar_url"`
+	InviteId  int       `json:"invite_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,79 @@
+package chats
+
+import "time"
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var roleRank = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// RoleRank возвращает уровень роли в чате; для неизвестной роли — 0
+func RoleRank(role string) int {
+	return roleRank[role]
+}
+
+type Permission string
+
+const (
+	PermRenameChat     Permission = "rename_chat"
+	PermManageMembers  Permission = "manage_members"
+	PermDeleteMessages Permission = "delete_messages"
+	PermChangeRoles    Permission = "change_roles"
+	PermManageInvites  Permission = "manage_invites"
+	PermDeleteChat     Permission = "delete_chat"
+)
+
+// minRole — минимальная роль, которой разрешено действие
+var minRole = map[Permission]string{
+	PermRenameChat:     RoleAdmin,
+	PermManageMembers:  RoleAdmin,
+	PermDeleteMessages: RoleAdmin,
+	PermChangeRoles:    RoleOwner,
+	PermManageInvites:  RoleOwner,
+	PermDeleteChat:     RoleOwner,
+}
+
+// Can сообщает, разрешено ли участнику с ролью role действие perm
+func Can(role string, perm Permission) bool {
+	required, ok := minRole[perm]
+	return ok && RoleRank(role) >= RoleRank(required)
+}
+
+// CanPost сообщает, может ли участник с ролью role писать в чат типа chatType.
+// В каналах публикуют только администраторы и владельцы.
+func CanPost(chatType, role string) bool {
+	if chatType == TypeChannel {
+		return RoleRank(role) >= RoleRank(RoleAdmin)
+	}
+	return true
+}
+
+// CanDeleteChat сообщает, может ли участник с ролью role удалить чат типа
+// chatType у всех. Группы и каналы удаляет владелец, а в личном чате
+// и «Избранном» старших нет, поэтому удалить их может любой участник.
+func CanDeleteChat(chatType, role string) bool {
+	if chatType == TypeDirect || chatType == TypeSaved {
+		return true
+	}
+	return Can(role, PermDeleteChat)
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// RoleEvent — запись журнала смены ролей и содержимое WebSocket-события role_changed
+type RoleEvent struct {
+	ActorId   int       `json:"actor_id"`
+	UserId    int       `json:"user_id"`
+	OldRole   string    `json:"old_role"`
+	NewRole   string    `json:"new_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
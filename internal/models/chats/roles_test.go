@@ -0,0 +1,82 @@
+package chats
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		perm    Permission
+		allowed string
+		denied  string
+	}{
+		{PermRenameChat, RoleAdmin, RoleMember},
+		{PermManageMembers, RoleAdmin, RoleMember},
+		{PermDeleteMessages, RoleAdmin, RoleMember},
+		{PermChangeRoles, RoleOwner, RoleAdmin},
+		{PermManageInvites, RoleOwner, RoleAdmin},
+		{PermDeleteChat, RoleOwner, RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		if !Can(tt.allowed, tt.perm) || !Can(RoleOwner, tt.perm) {
+			t.Errorf("%s: запрещено для %s", tt.perm, tt.allowed)
+		}
+		if Can(tt.denied, tt.perm) || Can("", tt.perm) {
+			t.Errorf("%s: разрешено для %s", tt.perm, tt.denied)
+		}
+	}
+
+	if Can(RoleOwner, Permission("unknown")) {
+		t.Error("неизвестное действие разрешено")
+	}
+}
+
+func TestRoleRank(t *testing.T) {
+	if !(RoleRank(RoleMember) < RoleRank(RoleAdmin) && RoleRank(RoleAdmin) < RoleRank(RoleOwner)) {
+		t.Error("роли не упорядочены member < admin < owner")
+	}
+	if RoleRank("guest") != 0 {
+		t.Error("неизвестная роль имеет ненулевой уровень")
+	}
+}
+
+func TestCanPost(t *testing.T) {
+	tests := []struct {
+		chatType string
+		role     string
+		want     bool
+	}{
+		{TypeDirect, RoleMember, true},
+		{TypeGroup, RoleMember, true},
+		{TypeChannel, RoleMember, false},
+		{TypeChannel, RoleAdmin, true},
+		{TypeChannel, RoleOwner, true},
+	}
+
+	for _, tt := range tests {
+		if got := CanPost(tt.chatType, tt.role); got != tt.want {
+			t.Errorf("CanPost(%s, %s) = %v", tt.chatType, tt.role, got)
+		}
+	}
+}
+
+func TestCanDeleteChat(t *testing.T) {
+	tests := []struct {
+		chatType string
+		role     string
+		want     bool
+	}{
+		{TypeDirect, RoleMember, true},
+		{TypeSaved, RoleOwner, true},
+		{TypeGroup, RoleMember, false},
+		{TypeGroup, RoleAdmin, false},
+		{TypeGroup, RoleOwner, true},
+		{TypeChannel, RoleAdmin, false},
+		{TypeChannel, RoleOwner, true},
+	}
+
+	for _, tt := range tests {
+		if got := CanDeleteChat(tt.chatType, tt.role); got != tt.want {
+			t.Errorf("CanDeleteChat(%s, %s) = %v", tt.chatType, tt.role, got)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package chats
+
+import "github.com/google/uuid"
+
+// ListFilter — фильтр списка чатов. По умолчанию возвращаются чаты
+// не из архива из всех папок.
+type ListFilter struct {
+	Archived bool
+	FolderId *int
+}
+
+// SettingsRequest — личные настройки чата, видимые только самому участнику.
+// Незаданные поля не меняются.
+type SettingsRequest struct {
+	Archived *bool `json:"archived"`
+	// MuteFor — на сколько секунд отключить уведомления: 0 включает их
+	// обратно, -1 отключает бессрочно
+	MuteFor *int  `json:"mute_for"`
+	Pinned  *bool `json:"pinned"`
+	// FolderId — папка чата; 0 убирает чат из папки
+	FolderId *int `json:"folder_id"`
+}
+
+type PinnedOrderRequest struct {
+	ChatIds []uuid.UUID `json:"chat_ids"`
+}
+
+type Folder struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Position  int    `json:"position"`
+	ChatCount int    `json:"chat_count"`
+}
+
+type FolderRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,28 @@
+package identities
+
+// LoginState — незавершенный вход через OIDC-провайдера
+type LoginState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	// Хэш значения cookie браузера, который начал вход
+	BindingHash string
+	// Если задан, внешний аккаунт привязывается к этому пользователю
+	LinkUserId *int
+}
+
+// External — проверенные данные внешнего аккаунта
+type External struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	DisplayName   string
+}
+
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginUrl    string `json:"login_url"`
+}
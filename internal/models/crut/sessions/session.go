@@ -0,0 +1,17 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	Id          uuid.UUID `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	Ip          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	Current     bool      `json:"current"`
+}
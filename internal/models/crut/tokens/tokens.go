@@ -0,0 +1,61 @@
+package tokens
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Префикс персональных токенов — по нему middleware отличает их от JWT
+const Prefix = "ggc_"
+
+const (
+	ScopeChatsRead  = "chats:read"
+	ScopeChatsWrite = "chats:write"
+	ScopeAiRead     = "ai:read"
+	ScopeAiWrite    = "ai:write"
+	ScopeUsersRead  = "users:read"
+)
+
+var Scopes = []string{
+	ScopeChatsRead,
+	ScopeChatsWrite,
+	ScopeAiRead,
+	ScopeAiWrite,
+	ScopeUsersRead,
+}
+
+type AccessToken struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type CreateRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+// CreateResponse содержит сам токен — он показывается только один раз
+type CreateResponse struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// WsTicket — одноразовый билет на подключение к WebSocket. Переносит
+// сессию или токен доступа и права того, кто его получил.
+type WsTicket struct {
+	UserId    int
+	SessionId *uuid.UUID
+	TokenId   *int
+	Scopes    []string
+}
+
+type WsTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
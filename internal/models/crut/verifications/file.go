@@ -3,10 +3,13 @@ package verifications
 type Request struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
 }
 
 type Response struct {
 	Id           int    `json:"id"`
 	Confirmation bool   `json:"confirmation"`
 	Massage      string `json:"massage"`
+	TotpRequired bool   `json:"totp_required,omitempty"`
+	Challenge    string `json:"challenge,omitempty"`
 }
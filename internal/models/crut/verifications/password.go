@@ -0,0 +1,15 @@
+package verifications
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type PasswordResetConfirm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
@@ -0,0 +1,19 @@
+package verifications
+
+type TotpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TotpLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TotpSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
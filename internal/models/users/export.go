@@ -0,0 +1,56 @@
+package users
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export — все персональные данные пользователя для выгрузки
+type Export struct {
+	Profile   *Profile         `json:"profile"`
+	Chats     []ExportChat     `json:"chats"`
+	Messages  []ExportMessage  `json:"messages"`
+	AiChats   []ExportAiChat   `json:"ai_chats"`
+	Documents []ExportDocument `json:"documents"`
+}
+
+type ExportChat struct {
+	Uuid     uuid.UUID `json:"uuid"`
+	Name     string    `json:"name"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ExportMessage — сообщение пользователя в зашифрованном виде вместе
+// с ключом, зашифрованным для самого пользователя
+type ExportMessage struct {
+	Id           int       `json:"id"`
+	ChatId       uuid.UUID `json:"chat_id"`
+	Content      string    `json:"content"`
+	EncryptedKey *string   `json:"encrypted_key"`
+	SentAt       time.Time `json:"sent_at"`
+}
+
+type ExportAiChat struct {
+	Id        int               `json:"id"`
+	Title     string            `json:"title"`
+	CreatedAt time.Time         `json:"created_at"`
+	Messages  []ExportAiMessage `json:"messages"`
+}
+
+type ExportAiMessage struct {
+	Content    string    `json:"content"`
+	SenderType string    `json:"sender_type"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+type ExportDocument struct {
+	AiChatId int    `json:"ai_chat_id"`
+	Name     string `json:"name"`
+	Path     string `json:"-"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
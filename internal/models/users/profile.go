@@ -0,0 +1,33 @@
+package users
+
+import "fmt"
+
+type Profile struct {
+	Id          int     `json:"id"`
+	Username    string  `json:"username"`
+	DisplayName string  `json:"display_name"`
+	AvatarUrl   *string `json:"avatar_url"`
+	Bio         string  `json:"bio"`
+	Timezone    string  `json:"timezone"`
+	Email       *string `json:"email,omitempty"`
+	// Настройки видны только самому пользователю
+	Discoverable *bool `json:"discoverable,omitempty"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName  *string `json:"display_name"`
+	Bio          *string `json:"bio"`
+	Timezone     *string `json:"timezone"`
+	Email        *string `json:"email"`
+	Discoverable *bool   `json:"discoverable"`
+}
+
+// AvatarUrl возвращает ссылку, по которой сервер отдает аватар пользователя,
+// или nil, если аватар не загружен.
+func AvatarUrl(userId int, hasAvatar bool) *string {
+	if !hasAvatar {
+		return nil
+	}
+	url := fmt.Sprintf("/api/v1/users/%d/avatar", userId)
+	return &url
+}
@@ -0,0 +1,16 @@
+package users
+
+// Summary — краткая карточка пользователя для списков и поиска
+type Summary struct {
+	Id          int     `json:"id"`
+	Username    string  `json:"username"`
+	DisplayName string  `json:"display_name"`
+	AvatarUrl   *string `json:"avatar_url"`
+}
+
+type SearchResponse struct {
+	Users   []Summary `json:"users"`
+	Limit   int       `json:"limit"`
+	Offset  int       `json:"offset"`
+	HasMore bool      `json:"has_more"`
+}
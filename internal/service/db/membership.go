@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memberTTL ограничивает, сколько проверка членства живет в кэше. Изменения
+// состава через этот сервис сбрасывают кэш сразу, TTL нужен на случай
+// нескольких экземпляров сервера с общей БД.
+const memberTTL = 30 * time.Second
+
+type memberEntry struct {
+	member    bool
+	expiresAt time.Time
+}
+
+type memberCache struct {
+	mu    sync.Mutex
+	chats map[uuid.UUID]map[int]memberEntry
+}
+
+func newMemberCache() *memberCache {
+	return &memberCache{chats: make(map[uuid.UUID]map[int]memberEntry)}
+}
+
+func (c *memberCache) get(chatId uuid.UUID, userId int) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.chats[chatId][userId]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return false, false
+	}
+	return entry.member, true
+}
+
+func (c *memberCache) set(chatId uuid.UUID, userId int, member bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	users, ok := c.chats[chatId]
+	if !ok {
+		users = make(map[int]memberEntry)
+		c.chats[chatId] = users
+	}
+
+	now := time.Now()
+	users[userId] = memberEntry{member: member, expiresAt: now.Add(memberTTL)}
+
+	// Заодно выбрасываем протухшие записи этого чата, чтобы кэш не рос бесконечно
+	for id, entry := range users {
+		if now.After(entry.expiresAt) {
+			delete(users, id)
+		}
+	}
+}
+
+func (c *memberCache) forget(chatId uuid.UUID, userIds ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(userIds) == 0 {
+		delete(c.chats, chatId)
+		return
+	}
+	for _, userId := range userIds {
+		delete(c.chats[chatId], userId)
+	}
+}
+
+func (c *memberCache) forgetUser(userId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, users := range c.chats {
+		delete(users, userId)
+	}
+}
+
+// IsMember проверяет, состоит ли пользователь в чате. Это единая проверка
+// доступа к чату для REST-запросов и WebSocket.
+func (ds *DbService) IsMember(ctx context.Context, chatId uuid.UUID, userId int) (bool, error) {
+	if member, ok := ds.members.get(chatId, userId); ok {
+		return member, nil
+	}
+
+	member, err := ds.repo.IsMember(ctx, chatId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	ds.members.set(chatId, userId, member)
+	return member, nil
+}
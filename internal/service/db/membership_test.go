@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemberCache(t *testing.T) {
+	chatA, chatB := uuid.New(), uuid.New()
+
+	fill := func() *memberCache {
+		c := newMemberCache()
+		c.set(chatA, 1, true)
+		c.set(chatA, 2, false)
+		c.set(chatB, 1, true)
+		return c
+	}
+
+	c := fill()
+	if member, ok := c.get(chatA, 2); !ok || member {
+		t.Errorf("отрицательный ответ не закэширован: %v %v", member, ok)
+	}
+	if _, ok := c.get(chatB, 2); ok {
+		t.Error("найдена запись, которой не было")
+	}
+
+	c.chats[chatA][1] = memberEntry{member: true, expiresAt: time.Now().Add(-time.Second)}
+	if _, ok := c.get(chatA, 1); ok {
+		t.Error("протухшая запись возвращена")
+	}
+	c.set(chatA, 3, true)
+	if _, ok := c.chats[chatA][1]; ok {
+		t.Error("set не удалил протухшую запись")
+	}
+
+	c = fill()
+	c.forget(chatA, 1)
+	if _, ok := c.get(chatA, 1); ok {
+		t.Error("forget(chat, user) не сбросил участника")
+	}
+	if _, ok := c.get(chatA, 2); !ok {
+		t.Error("forget(chat, user) сбросил другого участника")
+	}
+
+	c = fill()
+	c.forget(chatA)
+	if _, ok := c.get(chatA, 2); ok {
+		t.Error("forget(chat) не сбросил чат")
+	}
+	if _, ok := c.get(chatB, 1); !ok {
+		t.Error("forget(chat) сбросил другой чат")
+	}
+
+	c = fill()
+	c.forgetUser(1)
+	_, okA := c.get(chatA, 1)
+	_, okB := c.get(chatB, 1)
+	if okA || okB {
+		t.Error("forgetUser оставил записи пользователя")
+	}
+}
@@ -2,7 +2,12 @@ package db
 
 import (
 	"GGChat/internal/db"
+	"GGChat/internal/models/admin"
 	"GGChat/internal/models/chats"
+	"GGChat/internal/models/crut/identities"
+	"GGChat/internal/models/crut/sessions"
+	"GGChat/internal/models/crut/tokens"
+	"GGChat/internal/models/users"
 	"context"
 	"time"
 
@@ -10,37 +15,409 @@ import (
 )
 
 type DbService struct {
-	repo db.PgRepository
+	repo    db.PgRepository
+	members *memberCache
 }
 
 func NewDbService(repo db.PgRepository) *DbService {
-	return &DbService{repo: repo}
+	return &DbService{
+		repo:    repo,
+		members: newMemberCache(),
+	}
 }
 
 func (ds *DbService) UsersVerification(ctx context.Context, username, password string) (int, bool, error) {
 	return ds.repo.UsersVerification(ctx, username, password)
 }
 
-func (ds *DbService) NewUser(ctx context.Context, username, password string) (bool, int, error) {
-	return ds.repo.NewUser(ctx, username, password)
+func (ds *DbService) NewUser(ctx context.Context, username, password, email string) (bool, int, error) {
+	return ds.repo.NewUser(ctx, username, password, email)
 }
 
-func (ds *DbService) NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, error) {
-	return ds.repo.NewChat(ctx, chatName, UserId, other_user_id)
+func (ds *DbService) ChangePassword(ctx context.Context, userId int, oldPassword, newPassword string) (bool, error) {
+	return ds.repo.ChangePassword(ctx, userId, oldPassword, newPassword)
 }
 
-func (ds *DbService) DeleteChat(ctx context.Context, uuid uuid.UUID) error {
-	return ds.repo.DeleteChat(ctx, uuid)
+func (ds *DbService) RevokeOtherSessions(ctx context.Context, userId int, keepSessionId uuid.UUID) ([]uuid.UUID, error) {
+	return ds.repo.RevokeOtherSessions(ctx, userId, keepSessionId)
 }
 
-func (ds *DbService) GetAllChats(ctx context.Context, UserId int) ([]chats.Chat, error) {
-	return ds.repo.GetAllChats(ctx, UserId)
+func (ds *DbService) GetUserEmail(ctx context.Context, login string) (int, string, error) {
+	return ds.repo.GetUserEmail(ctx, login)
+}
+
+func (ds *DbService) CreatePasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	return ds.repo.CreatePasswordReset(ctx, userId, tokenHash, expiresAt)
+}
+
+func (ds *DbService) ResetPassword(ctx context.Context, tokenHash, newPassword string) (int, []uuid.UUID, error) {
+	return ds.repo.ResetPassword(ctx, tokenHash, newPassword)
+}
+
+func (ds *DbService) GetProfile(ctx context.Context, userId int) (*users.Profile, error) {
+	return ds.repo.GetProfile(ctx, userId)
+}
+
+func (ds *DbService) UpdateProfile(ctx context.Context, userId int, req users.UpdateProfileRequest) (*users.Profile, error) {
+	return ds.repo.UpdateProfile(ctx, userId, req)
+}
+
+func (ds *DbService) SetAvatar(ctx context.Context, userId int, path string) (string, error) {
+	return ds.repo.SetAvatar(ctx, userId, path)
+}
+
+func (ds *DbService) GetAvatarPath(ctx context.Context, userId int) (string, error) {
+	return ds.repo.GetAvatarPath(ctx, userId)
+}
+
+func (ds *DbService) CreateSession(ctx context.Context, userId int, refreshHash, deviceLabel, userAgent, ip string, expiresAt time.Time) (uuid.UUID, error) {
+	return ds.repo.CreateSession(ctx, userId, refreshHash, deviceLabel, userAgent, ip, expiresAt)
+}
+
+func (ds *DbService) RotateSession(ctx context.Context, sessionId uuid.UUID, oldHash, newHash string, expiresAt time.Time) (int, error) {
+	return ds.repo.RotateSession(ctx, sessionId, oldHash, newHash, expiresAt)
+}
+
+func (ds *DbService) RevokeSession(ctx context.Context, sessionId uuid.UUID, refreshHash string) error {
+	return ds.repo.RevokeSession(ctx, sessionId, refreshHash)
+}
+
+func (ds *DbService) TouchSession(ctx context.Context, sessionId uuid.UUID) (bool, error) {
+	return ds.repo.TouchSession(ctx, sessionId)
+}
+
+func (ds *DbService) GetSessions(ctx context.Context, userId int) ([]sessions.Session, error) {
+	return ds.repo.GetSessions(ctx, userId)
+}
+
+func (ds *DbService) RevokeUserSession(ctx context.Context, userId int, sessionId uuid.UUID) error {
+	return ds.repo.RevokeUserSession(ctx, userId, sessionId)
+}
+
+func (ds *DbService) GetTotp(ctx context.Context, userId int) (string, string, bool, error) {
+	return ds.repo.GetTotp(ctx, userId)
+}
+
+func (ds *DbService) SetTotpSecret(ctx context.Context, userId int, secret string) error {
+	return ds.repo.SetTotpSecret(ctx, userId, secret)
+}
+
+func (ds *DbService) EnableTotp(ctx context.Context, userId int, counter int64, recoveryHashes []string) error {
+	return ds.repo.EnableTotp(ctx, userId, counter, recoveryHashes)
+}
+
+func (ds *DbService) DisableTotp(ctx context.Context, userId int) error {
+	return ds.repo.DisableTotp(ctx, userId)
+}
+
+func (ds *DbService) UseTotpCounter(ctx context.Context, userId int, counter int64) (bool, error) {
+	return ds.repo.UseTotpCounter(ctx, userId, counter)
+}
+
+func (ds *DbService) UseRecoveryCode(ctx context.Context, userId int, codeHash string) (bool, error) {
+	return ds.repo.UseRecoveryCode(ctx, userId, codeHash)
+}
+
+func (ds *DbService) CreateLoginChallenge(ctx context.Context, userId int, expiresAt time.Time) (uuid.UUID, error) {
+	return ds.repo.CreateLoginChallenge(ctx, userId, expiresAt)
+}
+
+func (ds *DbService) CheckLoginChallenge(ctx context.Context, challengeId uuid.UUID, maxAttempts int) (int, error) {
+	return ds.repo.CheckLoginChallenge(ctx, challengeId, maxAttempts)
+}
+
+func (ds *DbService) ConsumeLoginChallenge(ctx context.Context, challengeId uuid.UUID) error {
+	return ds.repo.ConsumeLoginChallenge(ctx, challengeId)
+}
+
+func (ds *DbService) GetLoginAttempts(ctx context.Context, scope, key string) (int, time.Time, error) {
+	return ds.repo.GetLoginAttempts(ctx, scope, key)
+}
+
+func (ds *DbService) RegisterLoginFailure(ctx context.Context, scope, key string, window time.Duration) (int, error) {
+	return ds.repo.RegisterLoginFailure(ctx, scope, key, window)
+}
+
+func (ds *DbService) BlockLogin(ctx context.Context, scope, key string, until time.Time) error {
+	return ds.repo.BlockLogin(ctx, scope, key, until)
+}
+
+func (ds *DbService) ResetLoginAttempts(ctx context.Context, scope, key string) error {
+	return ds.repo.ResetLoginAttempts(ctx, scope, key)
+}
+
+func (ds *DbService) RegisterPasswordResetRequest(ctx context.Context, scope, key string, window time.Duration) (int, error) {
+	return ds.repo.RegisterPasswordResetRequest(ctx, scope, key, window)
+}
+
+func (ds *DbService) NewChat(ctx context.Context, chatName string, UserId int, other_user_id int) (bool, uuid.UUID, string, error) {
+	created, chatId, name, err := ds.repo.NewChat(ctx, chatName, UserId, other_user_id)
+	if err == nil {
+		ds.members.forget(chatId, UserId, other_user_id)
+	}
+	return created, chatId, name, err
+}
+
+func (ds *DbService) DeleteChat(ctx context.Context, uuid uuid.UUID, actorId int) (string, []int, error) {
+	avatarPath, memberIds, err := ds.repo.DeleteChat(ctx, uuid, actorId)
+	if err == nil {
+		ds.members.forget(uuid)
+	}
+	return avatarPath, memberIds, err
+}
+
+func (ds *DbService) LeaveChat(ctx context.Context, chatId uuid.UUID, userId int) (bool, string, []chats.RoleEvent, error) {
+	deleted, avatarPath, events, err := ds.repo.LeaveChat(ctx, chatId, userId)
+	if err == nil {
+		ds.members.forget(chatId, userId)
+	}
+	return deleted, avatarPath, events, err
+}
+
+func (ds *DbService) ClearChatHistory(ctx context.Context, chatId uuid.UUID, userId int) (int, error) {
+	return ds.repo.ClearChatHistory(ctx, chatId, userId)
+}
+
+func (ds *DbService) GetAllChats(ctx context.Context, UserId int, filter chats.ListFilter) ([]chats.Chat, error) {
+	return ds.repo.GetAllChats(ctx, UserId, filter)
 }
 
 func (ds *DbService) GetUser(ctx context.Context, username string) (int, error) {
 	return ds.repo.GetUser(ctx, username)
 }
 
+func (ds *DbService) SearchUsers(ctx context.Context, userId int, query string, limit, offset int) ([]users.Summary, error) {
+	return ds.repo.SearchUsers(ctx, userId, query, limit, offset)
+}
+
+func (ds *DbService) BlockUser(ctx context.Context, blockerId, blockedId int) error {
+	return ds.repo.BlockUser(ctx, blockerId, blockedId)
+}
+
+func (ds *DbService) UnblockUser(ctx context.Context, blockerId, blockedId int) error {
+	return ds.repo.UnblockUser(ctx, blockerId, blockedId)
+}
+
+func (ds *DbService) IsBlocked(ctx context.Context, userId, otherUserId int) (bool, error) {
+	return ds.repo.IsBlocked(ctx, userId, otherUserId)
+}
+
+func (ds *DbService) SavedChat(ctx context.Context, userId int) (uuid.UUID, error) {
+	chatId, err := ds.repo.SavedChat(ctx, userId)
+	if err == nil {
+		ds.members.forget(chatId, userId)
+	}
+	return chatId, err
+}
+
+func (ds *DbService) GetChatInfo(ctx context.Context, chatId uuid.UUID) (*chats.ChatInfo, error) {
+	return ds.repo.GetChatInfo(ctx, chatId)
+}
+
+func (ds *DbService) MarkChatRead(ctx context.Context, chatId uuid.UUID, userId, messageId int) error {
+	return ds.repo.MarkChatRead(ctx, chatId, userId, messageId)
+}
+
+func (ds *DbService) NewGroupChat(ctx context.Context, chatName, chatType string, ownerId int, memberIds []int) (uuid.UUID, error) {
+	return ds.repo.NewGroupChat(ctx, chatName, chatType, ownerId, memberIds)
+}
+
+func (ds *DbService) AddChatMembers(ctx context.Context, chatId uuid.UUID, actorId int, userIds []int) ([]int, error) {
+	added, err := ds.repo.AddChatMembers(ctx, chatId, actorId, userIds)
+	if err == nil {
+		ds.members.forget(chatId, added...)
+	}
+	return added, err
+}
+
+func (ds *DbService) RemoveChatMember(ctx context.Context, chatId uuid.UUID, actorId, userId int) ([]chats.RoleEvent, error) {
+	events, err := ds.repo.RemoveChatMember(ctx, chatId, actorId, userId)
+	if err == nil {
+		ds.members.forget(chatId, userId)
+	}
+	return events, err
+}
+
+func (ds *DbService) SetChatMemberRole(ctx context.Context, chatId uuid.UUID, actorId, userId int, role string) ([]chats.RoleEvent, error) {
+	return ds.repo.SetChatMemberRole(ctx, chatId, actorId, userId, role)
+}
+
+func (ds *DbService) GetChatRoleChanges(ctx context.Context, chatId uuid.UUID, actorId, limit int) ([]chats.RoleEvent, error) {
+	return ds.repo.GetChatRoleChanges(ctx, chatId, actorId, limit)
+}
+
+func (ds *DbService) DeleteChatMessage(ctx context.Context, chatId uuid.UUID, messageId, actorId int) error {
+	return ds.repo.DeleteChatMessage(ctx, chatId, messageId, actorId)
+}
+
+func (ds *DbService) EditMessage(ctx context.Context, chatId uuid.UUID, messageId, editorId int, content string, keys map[int]string, window time.Duration) (time.Time, map[int]string, error) {
+	return ds.repo.EditMessage(ctx, chatId, messageId, editorId, content, keys, window)
+}
+
+func (ds *DbService) GetMessageEdits(ctx context.Context, chatId uuid.UUID, messageId, userId int) ([]chats.MessageEdit, error) {
+	return ds.repo.GetMessageEdits(ctx, chatId, messageId, userId)
+}
+
+func (ds *DbService) UpdateChatSettings(ctx context.Context, chatId uuid.UUID, userId int, req chats.SettingsRequest) error {
+	return ds.repo.UpdateChatSettings(ctx, chatId, userId, req)
+}
+
+func (ds *DbService) ReorderPinnedChats(ctx context.Context, userId int, chatIds []uuid.UUID) error {
+	return ds.repo.ReorderPinnedChats(ctx, userId, chatIds)
+}
+
+func (ds *DbService) GetNotifyRecipients(ctx context.Context, chatId uuid.UUID, senderId int) ([]int, error) {
+	return ds.repo.GetNotifyRecipients(ctx, chatId, senderId)
+}
+
+func (ds *DbService) GetChatFolders(ctx context.Context, userId int) ([]chats.Folder, error) {
+	return ds.repo.GetChatFolders(ctx, userId)
+}
+
+func (ds *DbService) CreateChatFolder(ctx context.Context, userId int, name string) (*chats.Folder, error) {
+	return ds.repo.CreateChatFolder(ctx, userId, name)
+}
+
+func (ds *DbService) RenameChatFolder(ctx context.Context, userId, folderId int, name string) error {
+	return ds.repo.RenameChatFolder(ctx, userId, folderId, name)
+}
+
+func (ds *DbService) DeleteChatFolder(ctx context.Context, userId, folderId int) error {
+	return ds.repo.DeleteChatFolder(ctx, userId, folderId)
+}
+
+func (ds *DbService) UpdateChat(ctx context.Context, chatId uuid.UUID, actorId int, req chats.UpdateChatRequest) (*chats.ChatInfo, error) {
+	return ds.repo.UpdateChat(ctx, chatId, actorId, req)
+}
+
+func (ds *DbService) SetChatAvatar(ctx context.Context, chatId uuid.UUID, actorId int, path string) (string, *chats.ChatInfo, error) {
+	return ds.repo.SetChatAvatar(ctx, chatId, actorId, path)
+}
+
+func (ds *DbService) GetChatAvatarPath(ctx context.Context, chatId uuid.UUID) (string, error) {
+	return ds.repo.GetChatAvatarPath(ctx, chatId)
+}
+
+func (ds *DbService) CreateChatInvite(ctx context.Context, chatId uuid.UUID, actorId int, tokenHash string, req chats.InviteRequest, expiresAt *time.Time) (*chats.Invite, error) {
+	return ds.repo.CreateChatInvite(ctx, chatId, actorId, tokenHash, req, expiresAt)
+}
+
+func (ds *DbService) GetChatInvites(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.Invite, error) {
+	return ds.repo.GetChatInvites(ctx, chatId, actorId)
+}
+
+func (ds *DbService) RevokeChatInvite(ctx context.Context, chatId uuid.UUID, actorId, inviteId int) error {
+	return ds.repo.RevokeChatInvite(ctx, chatId, actorId, inviteId)
+}
+
+func (ds *DbService) PreviewChatInvite(ctx context.Context, tokenHash string, userId int) (*chats.InvitePreview, error) {
+	return ds.repo.PreviewChatInvite(ctx, tokenHash, userId)
+}
+
+func (ds *DbService) JoinChatByInvite(ctx context.Context, tokenHash string, userId int) (uuid.UUID, string, error) {
+	chatId, status, err := ds.repo.JoinChatByInvite(ctx, tokenHash, userId)
+	if err == nil {
+		ds.members.forget(chatId, userId)
+	}
+	return chatId, status, err
+}
+
+func (ds *DbService) GetJoinRequests(ctx context.Context, chatId uuid.UUID, actorId int) ([]chats.JoinRequest, error) {
+	return ds.repo.GetJoinRequests(ctx, chatId, actorId)
+}
+
+func (ds *DbService) DecideJoinRequest(ctx context.Context, chatId uuid.UUID, actorId, userId int, approve bool) error {
+	err := ds.repo.DecideJoinRequest(ctx, chatId, actorId, userId, approve)
+	if err == nil {
+		ds.members.forget(chatId, userId)
+	}
+	return err
+}
+
+func (ds *DbService) GetChatMembers(ctx context.Context, chatId uuid.UUID) ([]chats.Member, error) {
+	return ds.repo.GetChatMembers(ctx, chatId)
+}
+
+func (ds *DbService) CheckPassword(ctx context.Context, userId int, pass string) (bool, error) {
+	return ds.repo.CheckPassword(ctx, userId, pass)
+}
+
+func (ds *DbService) ExportUserData(ctx context.Context, userId int) (*users.Export, error) {
+	return ds.repo.ExportUserData(ctx, userId)
+}
+
+func (ds *DbService) DeleteUser(ctx context.Context, userId int) ([]uuid.UUID, string, error) {
+	sessions, avatar, err := ds.repo.DeleteUser(ctx, userId)
+	if err == nil {
+		ds.members.forgetUser(userId)
+	}
+	return sessions, avatar, err
+}
+
+func (ds *DbService) CreateAccessToken(ctx context.Context, userId int, name string, scopes []string, tokenHash string, expiresAt *time.Time) (*tokens.AccessToken, error) {
+	return ds.repo.CreateAccessToken(ctx, userId, name, scopes, tokenHash, expiresAt)
+}
+
+func (ds *DbService) GetAccessTokens(ctx context.Context, userId int) ([]tokens.AccessToken, error) {
+	return ds.repo.GetAccessTokens(ctx, userId)
+}
+
+func (ds *DbService) RevokeAccessToken(ctx context.Context, userId, tokenId int) error {
+	return ds.repo.RevokeAccessToken(ctx, userId, tokenId)
+}
+
+func (ds *DbService) CheckAccessToken(ctx context.Context, tokenHash string) (int, int, []string, error) {
+	return ds.repo.CheckAccessToken(ctx, tokenHash)
+}
+
+func (ds *DbService) CreateWsTicket(ctx context.Context, ticketHash string, ticket tokens.WsTicket, expiresAt time.Time) error {
+	return ds.repo.CreateWsTicket(ctx, ticketHash, ticket, expiresAt)
+}
+
+func (ds *DbService) ConsumeWsTicket(ctx context.Context, ticketHash string) (*tokens.WsTicket, error) {
+	return ds.repo.ConsumeWsTicket(ctx, ticketHash)
+}
+
+func (ds *DbService) GetUserRole(ctx context.Context, userId int) (string, error) {
+	return ds.repo.GetUserRole(ctx, userId)
+}
+
+func (ds *DbService) AdminListUsers(ctx context.Context, query string, limit, offset int) ([]admin.User, int, error) {
+	return ds.repo.AdminListUsers(ctx, query, limit, offset)
+}
+
+func (ds *DbService) SetUserDisabled(ctx context.Context, userId int, disabled bool) ([]uuid.UUID, error) {
+	return ds.repo.SetUserDisabled(ctx, userId, disabled)
+}
+
+func (ds *DbService) RevokeAllSessions(ctx context.Context, userId int) ([]uuid.UUID, error) {
+	return ds.repo.RevokeAllSessions(ctx, userId)
+}
+
+func (ds *DbService) SetUserRole(ctx context.Context, userId int, role string) error {
+	return ds.repo.SetUserRole(ctx, userId, role)
+}
+
+func (ds *DbService) GetStats(ctx context.Context, days int) (*admin.Stats, error) {
+	return ds.repo.GetStats(ctx, days)
+}
+
+func (ds *DbService) CreateOidcState(ctx context.Context, stateHash string, state identities.LoginState, expiresAt time.Time) error {
+	return ds.repo.CreateOidcState(ctx, stateHash, state, expiresAt)
+}
+
+func (ds *DbService) ConsumeOidcState(ctx context.Context, stateHash, provider, bindingHash string) (*identities.LoginState, error) {
+	return ds.repo.ConsumeOidcState(ctx, stateHash, provider, bindingHash)
+}
+
+func (ds *DbService) LoginWithIdentity(ctx context.Context, ext identities.External, allowSignup, linkByEmail bool) (int, error) {
+	return ds.repo.LoginWithIdentity(ctx, ext, allowSignup, linkByEmail)
+}
+
+func (ds *DbService) LinkIdentity(ctx context.Context, userId int, ext identities.External) error {
+	return ds.repo.LinkIdentity(ctx, userId, ext)
+}
+
 func (ds *DbService) NewMessage(ctx context.Context, chatId uuid.UUID, senderId int, encryptedContent string, encryptedKeys map[int]string) (int, string, error) {
 	return ds.repo.NewMessage(ctx, chatId, senderId, encryptedContent, encryptedKeys)
 }
@@ -49,8 +426,8 @@ func (ds *DbService) GetMessage(ctx context.Context, chatId uuid.UUID, currentUs
 	return ds.repo.GetMessage(ctx, chatId, currentUserId)
 }
 
-func (ds *DbService) UpdateMessageStatus(ctx context.Context, messageId int, status string) error {
-	return ds.repo.UpdateMessageStatus(ctx, messageId, status)
+func (ds *DbService) UpdateMessageStatus(ctx context.Context, chatId uuid.UUID, messageId, userId int, status string) (bool, error) {
+	return ds.repo.UpdateMessageStatus(ctx, chatId, messageId, userId, status)
 }
 
 func (ds *DbService) AddPublicKey(ctx context.Context, userId int, publicKey string) error {
@@ -0,0 +1,355 @@
+package keyset
+
+import (
+	"GGChat/internal/config"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+
+	rsaBits = 2048
+
+	// Как часто перечитывается каталог ключей и проверяется необходимость ротации
+	checkInterval = time.Minute
+
+	// Новый ключ начинает подписывать токены не сразу, а когда его успеют
+	// получить сервисы, закэшировавшие JWKS (см. Cache-Control у /.well-known/jwks.json)
+	PublishDelay = 5 * time.Minute
+)
+
+var ErrUnknownKey = errors.New("неизвестный идентификатор ключа")
+
+type Key struct {
+	Id        string
+	Alg       string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+	// Путь к файлу, если ключ лежит в каталоге ключей
+	path string
+}
+
+// KeySet хранит ключ, которым подписываются новые токены, и все ключи,
+// которыми еще можно проверить выданные ранее токены.
+type KeySet struct {
+	cfg    config.Jwt
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active *Key
+	// Ключи из конфига не удаляются при очистке
+	static map[string]bool
+}
+
+func New(cfg config.Jwt) (*KeySet, error) {
+	if cfg.Algorithm != AlgEdDSA && cfg.Algorithm != AlgRS256 {
+		return nil, fmt.Errorf("неподдерживаемый алгоритм подписи JWT: %s", cfg.Algorithm)
+	}
+
+	ks := &KeySet{
+		cfg:    cfg,
+		keys:   make(map[string]*Key),
+		static: make(map[string]bool),
+	}
+
+	for _, keyCfg := range cfg.Keys {
+		key, err := loadConfigKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[key.Id] = key
+		ks.static[key.Id] = true
+	}
+
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	empty := ks.active == nil
+	ks.mu.RUnlock()
+
+	if empty {
+		if cfg.KeyDir == "" {
+			logrus.Warn("Ключи JWT не настроены, сгенерирован временный ключ — после перезапуска токены доступа станут недействительны")
+		}
+		if err := ks.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+// Reload перечитывает каталог ключей, чтобы подхватить ключи,
+// сгенерированные другими экземплярами сервера.
+func (ks *KeySet) Reload() error {
+	if ks.cfg.KeyDir == "" {
+		ks.mu.Lock()
+		ks.pickActive()
+		ks.mu.Unlock()
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ks.cfg.KeyDir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать каталог ключей: %w", err)
+	}
+
+	loaded := make(map[string]*Key, len(paths))
+	for _, path := range paths {
+		key, err := loadFile(path)
+		if err != nil {
+			return err
+		}
+		loaded[key.Id] = key
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for id, key := range ks.keys {
+		if key.path != "" && loaded[id] == nil {
+			delete(ks.keys, id)
+		}
+	}
+	for id, key := range loaded {
+		if !ks.static[id] {
+			ks.keys[id] = key
+		}
+	}
+	ks.pickActive()
+
+	return nil
+}
+
+// pickActive делает активным самый новый опубликованный ключ, у которого
+// есть закрытая часть. Если опубликованных нет, берется самый новый.
+func (ks *KeySet) pickActive() {
+	published := time.Now().Add(-PublishDelay)
+
+	ks.active = nil
+	for _, key := range ks.keys {
+		if key.Private == nil {
+			continue
+		}
+		if ks.active == nil {
+			ks.active = key
+			continue
+		}
+
+		activePublished := !ks.active.CreatedAt.After(published)
+		keyPublished := !key.CreatedAt.After(published)
+		switch {
+		case keyPublished && !activePublished:
+			ks.active = key
+		case keyPublished == activePublished && key.CreatedAt.After(ks.active.CreatedAt):
+			ks.active = key
+		}
+	}
+}
+
+// newest возвращает время создания самого нового ключа с закрытой частью
+func (ks *KeySet) newest() time.Time {
+	var newest time.Time
+	for _, key := range ks.keys {
+		if key.Private != nil && key.CreatedAt.After(newest) {
+			newest = key.CreatedAt
+		}
+	}
+	return newest
+}
+
+// Rotate генерирует новый ключ. Активным он станет через PublishDelay,
+// а старые ключи остаются для проверки, пока не истекут подписанные ими токены.
+func (ks *KeySet) Rotate() error {
+	key, err := generate(ks.cfg.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if ks.cfg.KeyDir != "" {
+		if err := save(ks.cfg.KeyDir, key); err != nil {
+			return err
+		}
+	}
+
+	ks.mu.Lock()
+	ks.keys[key.Id] = key
+	ks.pickActive()
+	ks.mu.Unlock()
+
+	logrus.Info("Новый ключ подписи JWT: ", key.Id)
+	return nil
+}
+
+// prune удаляет ключи, которые были заменены более новыми раньше, чем
+// живет токен доступа: подписанных ими действующих токенов уже нет.
+func (ks *KeySet) prune() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.active == nil {
+		return
+	}
+
+	keys := make([]*Key, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	deadline := time.Now().Add(-ks.cfg.AccessTTL - checkInterval)
+	for i := 0; i < len(keys)-1; i++ {
+		key := keys[i]
+		if key == ks.active || ks.static[key.Id] || key.Private == nil {
+			continue
+		}
+		// Ключ еще может подписывать или недавно подписывал токены
+		if !key.CreatedAt.Before(ks.active.CreatedAt) || keys[i+1].CreatedAt.Add(PublishDelay).After(deadline) {
+			continue
+		}
+
+		delete(ks.keys, key.Id)
+		if key.path != "" {
+			os.Remove(key.path)
+		}
+		logrus.Info("Удален устаревший ключ подписи JWT: ", key.Id)
+	}
+}
+
+// Run периодически перечитывает ключи и выполняет ротацию по расписанию.
+// Ротация работает только с каталогом ключей: иначе новый ключ увидел бы
+// лишь текущий экземпляр сервера.
+func (ks *KeySet) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Reload(); err != nil {
+				logrus.Error("Ошибка перечитывания ключей JWT: ", err)
+				continue
+			}
+
+			ks.mu.RLock()
+			due := ks.active != nil && ks.cfg.KeyDir != "" && ks.cfg.RotationInterval > 0 && time.Since(ks.newest()) >= ks.cfg.RotationInterval
+			ks.mu.RUnlock()
+
+			if due {
+				if err := ks.Rotate(); err != nil {
+					logrus.Error("Ошибка ротации ключа JWT: ", err)
+					continue
+				}
+			}
+
+			ks.prune()
+		}
+	}
+}
+
+// Sign подписывает claims активным ключом и выставляет заголовок kid
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.active
+	ks.mu.RUnlock()
+
+	if key == nil {
+		return "", errors.New("нет активного ключа подписи JWT")
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.Id
+
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc возвращает публичный ключ по заголовку kid для jwt.Parse
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("алгоритм токена %s не совпадает с алгоритмом ключа %s", token.Method.Alg(), key.Alg)
+	}
+
+	return key.Public, nil
+}
+
+// Algorithms — список алгоритмов, которые принимаются при проверке токенов
+func Algorithms() []string {
+	return []string{AlgEdDSA, AlgRS256}
+}
+
+// ParserOptions — проверки, общие для всех мест, где разбираются токены сервера:
+// алгоритм, наличие exp, issuer и, если настроена, аудитория.
+func ParserOptions(cfg config.Jwt) []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(cfg.Issuer),
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return opts
+}
+
+func generate(alg string) (*Key, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("не удалось сгенерировать идентификатор ключа: %w", err)
+	}
+
+	key := &Key{
+		Id:        time.Now().UTC().Format("20060102T150405Z") + "-" + strings.ToLower(alg) + "-" + hex.EncodeToString(suffix),
+		Alg:       alg,
+		CreatedAt: time.Now(),
+	}
+
+	switch alg {
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось сгенерировать ключ: %w", err)
+		}
+		key.Private, key.Public = private, public
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось сгенерировать ключ: %w", err)
+		}
+		key.Private, key.Public = private, &private.PublicKey
+	default:
+		return nil, fmt.Errorf("неподдерживаемый алгоритм подписи JWT: %s", alg)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,188 @@
+package keyset
+
+import (
+	"GGChat/internal/config"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyAged генерирует ключ, созданный age назад
+func keyAged(t *testing.T, alg string, age time.Duration) *Key {
+	key, err := generate(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Id += "-" + age.String()
+	key.CreatedAt = time.Now().Add(-age)
+	return key
+}
+
+func withKeys(cfg config.Jwt, keys ...*Key) *KeySet {
+	ks := &KeySet{cfg: cfg, keys: make(map[string]*Key), static: make(map[string]bool)}
+	for _, key := range keys {
+		ks.keys[key.Id] = key
+	}
+	ks.pickActive()
+	return ks
+}
+
+func TestPickActive(t *testing.T) {
+	old := keyAged(t, AlgEdDSA, time.Hour)
+	published := keyAged(t, AlgEdDSA, PublishDelay+time.Minute)
+	fresh := keyAged(t, AlgEdDSA, time.Minute)
+	fresher := keyAged(t, AlgEdDSA, time.Second)
+	verifyOnly := keyAged(t, AlgEdDSA, 10*time.Minute)
+	verifyOnly.Private = nil
+
+	tests := []struct {
+		name string
+		keys []*Key
+		want *Key
+	}{
+		{"опубликованный ключ заменяет старый", []*Key{old, published}, published},
+		{"неопубликованный ключ ждет", []*Key{old, fresh}, old},
+		{"без опубликованных — самый новый", []*Key{fresh, fresher}, fresher},
+		{"ключ только для проверки", []*Key{old, verifyOnly}, old},
+		{"нечем подписывать", []*Key{verifyOnly}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := withKeys(config.Jwt{}, tt.keys...).active; got != tt.want {
+			t.Errorf("%s: выбран не тот ключ", tt.name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	cfg := config.Jwt{Algorithm: AlgEdDSA, KeyDir: t.TempDir(), AccessTTL: 15 * time.Minute}
+
+	ks, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := ks.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.keys) != 2 {
+		t.Fatalf("после ротации %d ключей, ожидалось 2", len(ks.keys))
+	}
+	if _, err := jwt.Parse(signed, ks.Keyfunc, jwt.WithValidMethods(Algorithms())); err != nil {
+		t.Errorf("токен прежнего ключа не проверяется: %v", err)
+	}
+
+	// Второй экземпляр сервера видит те же ключи через каталог
+	other, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.keys) != 2 || other.active.Id != ks.active.Id {
+		t.Errorf("из каталога прочитано %d ключей, активный %s вместо %s", len(other.keys), other.active.Id, ks.active.Id)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cfg := config.Jwt{AccessTTL: 15 * time.Minute}
+	retired := cfg.AccessTTL + checkInterval + PublishDelay
+
+	tests := []struct {
+		name       string
+		oldAge     time.Duration
+		newAge     time.Duration
+		wantPruned bool
+	}{
+		{"замененный давно", 3 * retired, 2 * retired, true},
+		{"замененный недавно", retired, PublishDelay + time.Minute, false},
+		{"замена еще не опубликована", 3 * retired, time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		old := keyAged(t, AlgEdDSA, tt.oldAge)
+		ks := withKeys(cfg, old, keyAged(t, AlgEdDSA, tt.newAge))
+		ks.prune()
+
+		if _, kept := ks.keys[old.Id]; kept == tt.wantPruned {
+			t.Errorf("%s: старый ключ сохранен = %v", tt.name, kept)
+		}
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	ed := keyAged(t, AlgEdDSA, time.Hour)
+	rs := keyAged(t, AlgRS256, time.Hour)
+	ks := withKeys(config.Jwt{}, ed, rs)
+
+	tests := []struct {
+		name    string
+		kid     string
+		method  jwt.SigningMethod
+		want    any // nil — токен отклоняется
+		unknown bool
+	}{
+		{"EdDSA", ed.Id, jwt.SigningMethodEdDSA, ed.Public, false},
+		{"RS256", rs.Id, jwt.SigningMethodRS256, rs.Public, false},
+		{"неизвестный kid", "missing", jwt.SigningMethodEdDSA, nil, true},
+		{"без kid", "", jwt.SigningMethodEdDSA, nil, true},
+		{"RS256 с ключом EdDSA", ed.Id, jwt.SigningMethodRS256, nil, false},
+		{"HS256 с публичным ключом", rs.Id, jwt.SigningMethodHS256, nil, false},
+	}
+
+	for _, tt := range tests {
+		token := jwt.New(tt.method)
+		if tt.kid != "" {
+			token.Header["kid"] = tt.kid
+		}
+
+		got, err := ks.Keyfunc(token)
+		if tt.want == nil {
+			if err == nil || errors.Is(err, ErrUnknownKey) != tt.unknown {
+				t.Errorf("%s: ошибка %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ключ не найден: %v", tt.name, err)
+		}
+	}
+}
+
+func TestParserOptions(t *testing.T) {
+	key := keyAged(t, AlgEdDSA, time.Hour)
+	ks := withKeys(config.Jwt{}, key)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		audience string
+		claims   jwt.MapClaims
+		ok       bool
+	}{
+		{"свой issuer", "", jwt.MapClaims{"iss": "ggchat", "exp": exp}, true},
+		{"чужой issuer", "", jwt.MapClaims{"iss": "other", "exp": exp}, false},
+		{"без issuer", "", jwt.MapClaims{"exp": exp}, false},
+		{"без exp", "", jwt.MapClaims{"iss": "ggchat"}, false},
+		{"своя аудитория", "api", jwt.MapClaims{"iss": "ggchat", "aud": "api", "exp": exp}, true},
+		{"чужая аудитория", "api", jwt.MapClaims{"iss": "ggchat", "aud": "other", "exp": exp}, false},
+		{"без аудитории", "api", jwt.MapClaims{"iss": "ggchat", "exp": exp}, false},
+	}
+
+	for _, tt := range tests {
+		signed, err := ks.Sign(tt.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := ParserOptions(config.Jwt{Issuer: "ggchat", Audience: tt.audience})
+		if _, err := jwt.Parse(signed, ks.Keyfunc, opts...); (err == nil) != tt.ok {
+			t.Errorf("%s: ошибка проверки %v", tt.name, err)
+		}
+	}
+}
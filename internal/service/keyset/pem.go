@@ -0,0 +1,134 @@
+package keyset
+
+import (
+	"GGChat/internal/config"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadFile читает ключ из каталога ключей. Идентификатор ключа — имя файла
+// без расширения, время создания — время изменения файла.
+func loadFile(path string) (*Key, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать ключ %s: %w", path, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать ключ %s: %w", path, err)
+	}
+
+	key, err := parsePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("неверный ключ %s: %w", path, err)
+	}
+
+	key.Id = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	key.CreatedAt = info.ModTime()
+	key.path = path
+
+	return key, nil
+}
+
+func loadConfigKey(cfg config.JwtKey) (*Key, error) {
+	if cfg.Id == "" {
+		return nil, fmt.Errorf("у ключа JWT в конфиге не задан id")
+	}
+
+	path := cfg.PrivateKeyFile
+	if path == "" {
+		path = cfg.PublicKeyFile
+	}
+
+	key, err := loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Id = cfg.Id
+	key.path = ""
+
+	return key, nil
+}
+
+func parsePEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("PEM-блок не найден")
+	}
+
+	key := &Key{}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("неподдерживаемый тип ключа")
+		}
+		key.Private = signer
+		key.Public = signer.Public()
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Public = parsed
+	default:
+		return nil, fmt.Errorf("неподдерживаемый тип PEM-блока: %s", block.Type)
+	}
+
+	switch key.Public.(type) {
+	case ed25519.PublicKey:
+		key.Alg = AlgEdDSA
+	case *rsa.PublicKey:
+		key.Alg = AlgRS256
+	default:
+		return nil, fmt.Errorf("поддерживаются только ключи Ed25519 и RSA")
+	}
+
+	return key, nil
+}
+
+func save(dir string, key *Key) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("не удалось создать каталог ключей: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return fmt.Errorf("не удалось сериализовать ключ: %w", err)
+	}
+
+	path := filepath.Join(dir, key.Id+".pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// Пишем во временный файл и переименовываем, чтобы другие экземпляры
+	// не прочитали ключ наполовину
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("не удалось сохранить ключ: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("не удалось сохранить ключ: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		key.CreatedAt = info.ModTime()
+	}
+	key.path = path
+
+	return nil
+}
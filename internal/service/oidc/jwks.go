@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Не чаще этого интервала перезагружаем JWKS в поисках неизвестного kid
+const jwksMinRefresh = time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keyCache хранит ключи провайдера и перезагружает их, когда встречается
+// неизвестный kid — так переживается ротация ключей у провайдера
+type keyCache struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+func newKeyCache(client *http.Client) *keyCache {
+	return &keyCache{client: client}
+}
+
+func (c *keyCache) get(ctx context.Context, jwksUri, kid string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(c.fetchedAt) < jwksMinRefresh {
+		return nil, fmt.Errorf("ключ %q не найден в JWKS провайдера", kid)
+	}
+
+	keys, err := c.fetch(ctx, jwksUri)
+	if err != nil {
+		return nil, err
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("ключ %q не найден в JWKS провайдера", kid)
+}
+
+// lookup ищет ключ по kid; если kid в токене нет, подходит единственный ключ
+func (c *keyCache) lookup(kid string) (any, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *keyCache) fetch(ctx context.Context, jwksUri string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksUri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS вернул %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("неверный формат JWKS: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Ключи неподдерживаемых типов просто пропускаем
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("неподдерживаемая кривая %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("неподдерживаемая кривая %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("неверный ключ Ed25519")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("неподдерживаемый тип ключа %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("неверное значение в JWK")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
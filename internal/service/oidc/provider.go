@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"GGChat/internal/config"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const httpTimeout = 10 * time.Second
+
+var ErrUnknownProvider = errors.New("неизвестный OIDC-провайдер")
+
+// discovery — нужная нам часть документа /.well-known/openid-configuration
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Identity — проверенные данные пользователя из id_token
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Nonce             string
+}
+
+type idTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     any    `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+type Provider struct {
+	cfg    config.OidcProvider
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keyCache
+}
+
+// Providers — настроенные провайдеры по имени
+type Providers map[string]*Provider
+
+func New(cfg config.Oidc) Providers {
+	providers := make(Providers, len(cfg.Providers))
+	for _, p := range cfg.Providers {
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+
+		client := &http.Client{Timeout: httpTimeout}
+		providers[p.Name] = &Provider{
+			cfg:    p,
+			client: client,
+			keys:   newKeyCache(client),
+		}
+	}
+	return providers
+}
+
+func (ps Providers) Get(name string) (*Provider, error) {
+	p, ok := ps[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+func (p *Provider) AllowSignup() bool {
+	return p.cfg.AllowSignup
+}
+
+func (p *Provider) LinkByEmail() bool {
+	return p.cfg.LinkByEmail
+}
+
+// discover загружает настройки провайдера при первом обращении, чтобы
+// сервер запускался, даже когда провайдер временно недоступен
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var doc discovery
+	if err := p.getJSON(ctx, wellKnown, &doc); err != nil {
+		return nil, fmt.Errorf("не удалось загрузить настройки OIDC-провайдера %s: %w", p.cfg.Name, err)
+	}
+
+	if doc.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("issuer провайдера %q не совпадает с настроенным %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JwksUri == "" {
+		return nil, fmt.Errorf("неполные настройки OIDC-провайдера %s", p.cfg.Name)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// AuthURL возвращает адрес страницы входа провайдера с PKCE (S256)
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientId},
+		"redirect_uri":          {p.cfg.RedirectUrl},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange обменивает код авторизации на токены и проверяет id_token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Identity, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectUrl},
+		"client_id":     {p.cfg.ClientId},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientId), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса к token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа token endpoint: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint вернул %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("неверный ответ token endpoint: %w", err)
+	}
+	if tokens.IdToken == "" {
+		return nil, errors.New("провайдер не вернул id_token")
+	}
+
+	return p.verify(ctx, doc, tokens.IdToken)
+}
+
+func (p *Provider) verify(ctx context.Context, doc *discovery, idToken string) (*Identity, error) {
+	claims := &idTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.keys.get(ctx, doc.JwksUri, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "PS256", "EdDSA"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.cfg.ClientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("id_token не прошел проверку: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("в id_token нет sub")
+	}
+
+	identity := &Identity{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Nonce:             claims.Nonce,
+	}
+
+	// Некоторые провайдеры отдают email_verified строкой
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s вернул %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// CodeChallenge строит PKCE code_challenge по методу S256
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"GGChat/internal/config"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer поднимает провайдера с discovery, JWKS из одного ключа k1
+// и token endpoint, который принимает code_verifier "verifier" и отдает *idToken
+func fakeIssuer(t *testing.T, key ed25519.PrivateKey, idToken *string) *httptest.Server {
+	var srv *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			JwksUri:               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		x := base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {{Kty: "OKP", Kid: "k1", Crv: "Ed25519", X: x}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code_verifier") != "verifier" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": *idToken})
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExchange(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	var idToken string
+	srv := fakeIssuer(t, key, &idToken)
+
+	claims := func(change map[string]any) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":            srv.URL,
+			"aud":            "ggchat",
+			"sub":            "42",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          "n-0S6",
+			"email":          "alice@example.com",
+			"email_verified": "true",
+			"name":           "Alice",
+		}
+		for k, v := range change {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	sign := func(c jwt.MapClaims, method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, c)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	eddsa := jwt.SigningMethodEdDSA
+
+	tests := []struct {
+		name     string
+		idToken  string
+		verifier string
+		ok       bool
+	}{
+		{"действительный", sign(claims(nil), eddsa, "k1", key), "verifier", true},
+		{"чужой code_verifier", sign(claims(nil), eddsa, "k1", key), "other", false},
+		{"другая аудитория", sign(claims(map[string]any{"aud": "other"}), eddsa, "k1", key), "verifier", false},
+		{"другой issuer", sign(claims(map[string]any{"iss": "https://evil.example"}), eddsa, "k1", key), "verifier", false},
+		{"истек", sign(claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}), eddsa, "k1", key), "verifier", false},
+		{"без exp", sign(claims(map[string]any{"exp": nil}), eddsa, "k1", key), "verifier", false},
+		{"без sub", sign(claims(map[string]any{"sub": nil}), eddsa, "k1", key), "verifier", false},
+		{"чужой ключ", sign(claims(nil), eddsa, "k1", otherKey), "verifier", false},
+		{"неизвестный kid", sign(claims(nil), eddsa, "k2", key), "verifier", false},
+		{"alg none", sign(claims(nil), jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType), "verifier", false},
+		{"без id_token", "", "verifier", false},
+	}
+
+	for _, tt := range tests {
+		idToken = tt.idToken
+		provider := New(config.Oidc{Providers: []config.OidcProvider{{Name: "test", Issuer: srv.URL, ClientId: "ggchat"}}})["test"]
+
+		identity, err := provider.Exchange(context.Background(), "code", tt.verifier)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: id_token принят", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		want := Identity{Subject: "42", Email: "alice@example.com", EmailVerified: true, Name: "Alice", Nonce: "n-0S6"}
+		if *identity != want {
+			t.Errorf("%s: %+v, ожидалось %+v", tt.name, *identity, want)
+		}
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	srv := fakeIssuer(t, key, new(string))
+
+	provider := New(config.Oidc{Providers: []config.OidcProvider{{Name: "test", Issuer: srv.URL, ClientId: "ggchat"}}})["test"]
+	authUrl, err := provider.AuthURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authUrl)
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("state") != "state" || query.Get("nonce") != "nonce" ||
+		query.Get("code_challenge") != CodeChallenge("verifier") || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("неверный адрес входа: %s", authUrl)
+	}
+
+	// issuer из discovery должен совпадать с настроенным побайтно
+	provider = New(config.Oidc{Providers: []config.OidcProvider{{Name: "test", Issuer: srv.URL + "/", ClientId: "ggchat"}}})["test"]
+	if _, err := provider.AuthURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("принят discovery с другим issuer")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636, приложение B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallenge = %s", got)
+	}
+}
@@ -0,0 +1,97 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Параметры argon2id по умолчанию (рекомендации OWASP).
+const (
+	memory      uint32 = 64 * 1024
+	iterations  uint32 = 3
+	parallelism uint8  = 2
+	saltLength         = 16
+	keyLength   uint32 = 32
+)
+
+const prefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("неверный формат хэша пароля")
+
+// Hash возвращает хэш пароля в формате
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("не удалось сгенерировать соль: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, keyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefix,
+		argon2.Version,
+		memory, iterations, parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// IsHashed сообщает, хранится ли пароль уже в виде хэша.
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, prefix)
+}
+
+// Verify сравнивает пароль с сохраненным значением. Старые строки, в которых
+// пароль хранится открытым текстом, тоже поддерживаются — в этом случае
+// needsRehash будет true, и вызывающий код должен перезаписать значение хэшем.
+func Verify(password, stored string) (ok bool, needsRehash bool, err error) {
+	if !IsHashed(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+		return ok, ok, nil
+	}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return false, false, ErrInvalidHash
+	}
+
+	var m, t uint32
+	var p uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+		return false, false, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	// Хэши со старыми параметрами обновляем так же, как и открытый текст.
+	needsRehash = m != memory || t != iterations || p != parallelism || uint32(len(key)) != keyLength
+
+	return true, needsRehash, nil
+}
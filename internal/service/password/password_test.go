@@ -0,0 +1,94 @@
+package password
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashVerify(t *testing.T) {
+	hash, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHashed(hash) || strings.Contains(hash, "correct horse") {
+		t.Fatalf("неожиданный хэш: %s", hash)
+	}
+
+	other, _ := Hash("correct horse")
+	if other == hash {
+		t.Error("два хэша одного пароля совпали — соль не используется")
+	}
+
+	// Хэш со старыми параметрами argon2id
+	salt := []byte("0123456789abcdef")
+	weak := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 16*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("correct horse"), salt, 1, 16*1024, 1, keyLength)))
+
+	tests := []struct {
+		name        string
+		password    string
+		stored      string
+		ok          bool
+		needsRehash bool
+	}{
+		{"верный пароль", "correct horse", hash, true, false},
+		{"неверный пароль", "battery staple", hash, false, false},
+		{"пустой пароль", "", hash, false, false},
+		{"открытый текст совпал", "correct horse", "correct horse", true, true},
+		{"открытый текст не совпал", "battery staple", "correct horse", false, false},
+		{"старые параметры", "correct horse", weak, true, true},
+		{"старые параметры, неверный пароль", "battery staple", weak, false, false},
+	}
+
+	for _, tt := range tests {
+		ok, needsRehash, err := Verify(tt.password, tt.stored)
+		if err != nil || ok != tt.ok || needsRehash != tt.needsRehash {
+			t.Errorf("%s: Verify = %v, %v, %v; ожидалось %v, %v", tt.name, ok, needsRehash, err, tt.ok, tt.needsRehash)
+		}
+	}
+}
+
+// Вход с паролем открытым текстом перезаписывает его хэшем (UsersVerification):
+// после этого тот же пароль проверяется уже без повторного перехеширования
+func TestPlaintextUpgrade(t *testing.T) {
+	stored := "hunter2"
+
+	ok, needsRehash, _ := Verify("hunter2", stored)
+	if !ok || !needsRehash {
+		t.Fatalf("открытый текст: ok=%v needsRehash=%v", ok, needsRehash)
+	}
+
+	upgraded, err := Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needsRehash, err = Verify("hunter2", upgraded)
+	if !ok || needsRehash || err != nil {
+		t.Errorf("после обновления: ok=%v needsRehash=%v err=%v", ok, needsRehash, err)
+	}
+	// Строку хэша нельзя подставить вместо пароля
+	if ok, _, _ := Verify(upgraded, upgraded); ok {
+		t.Error("хэш принят как пароль")
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	for _, stored := range []string{
+		"$argon2id$",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA",
+		"$argon2id$v=18$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5",
+	} {
+		if _, _, err := Verify("password", stored); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("%s: ошибка %v, ожидалась ErrInvalidHash", stored, err)
+		}
+	}
+}
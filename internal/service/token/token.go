@@ -0,0 +1,30 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const size = 32
+
+// New генерирует случайный токен и возвращает его вместе с хэшем.
+// В базе данных хранится только хэш, сам токен отдается клиенту.
+func New() (string, string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("не удалось сгенерировать токен: %w", err)
+	}
+
+	plain := base64.RawURLEncoding.EncodeToString(buf)
+
+	return plain, Hash(plain), nil
+}
+
+// Hash возвращает SHA-256 хэш токена в hex-представлении.
+func Hash(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Параметры RFC 6238, которые понимают все распространенные приложения-аутентификаторы.
+const (
+	period     = 30
+	digits     = 6
+	secretSize = 20
+	// Допустимое расхождение часов клиента и сервера в шагах.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret возвращает новый секрет в base32.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, secretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("не удалось сгенерировать секрет TOTP: %w", err)
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// URI строит otpauth:// ссылку для QR-кода приложения-аутентификатора.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(period))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Validate проверяет код на момент времени t и возвращает номер временного шага,
+// которому он соответствует. Номер шага нужно сохранить, чтобы не принять
+// тот же код повторно.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	counter := t.Unix() / period
+	for i := -skew; i <= skew; i++ {
+		c := counter + int64(i)
+		if subtle.ConstantTimeCompare([]byte(generate(key, c)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+
+	return 0, false
+}
+
+func generate(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
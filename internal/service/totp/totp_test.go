@@ -0,0 +1,85 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Ключ из RFC 6238 для HMAC-SHA1
+var rfcKey = []byte("12345678901234567890")
+
+func TestGenerateRFC6238(t *testing.T) {
+	// Приложение B RFC 6238 дает 8-значные коды, мы выдаем последние 6 цифр
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	secret := encoding.EncodeToString(rfcKey)
+	for _, tt := range tests {
+		if got := generate(rfcKey, tt.unix/period); got != tt.code {
+			t.Errorf("T=%d: код %s, ожидался %s", tt.unix, got, tt.code)
+		}
+
+		counter, ok := Validate(secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || counter != tt.unix/period {
+			t.Errorf("T=%d: Validate = %d, %v", tt.unix, counter, ok)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	const step = 1234567890 / period
+	code := generate(rfcKey, step)
+	start := int64(step * period)
+
+	// Код шага step принимается на шаг раньше и на шаг позже, но не дальше
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{"начало шага", start, true},
+		{"конец шага", start + period - 1, true},
+		{"следующий шаг", start + period, true},
+		{"конец следующего шага", start + 2*period - 1, true},
+		{"через два шага", start + 2*period, false},
+		{"предыдущий шаг", start - period, true},
+		{"два шага назад", start - period - 1, false},
+	}
+
+	for _, tt := range tests {
+		counter, ok := Validate(secret, code, time.Unix(tt.unix, 0))
+		if ok != tt.ok {
+			t.Errorf("%s: принят = %v", tt.name, ok)
+		}
+		if ok && counter != step {
+			t.Errorf("%s: шаг %d, ожидался %d", tt.name, counter, step)
+		}
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	now := time.Unix(59, 0)
+
+	if _, ok := Validate(secret, " 287082 ", now); !ok {
+		t.Error("код с пробелами по краям не принят")
+	}
+	for _, code := range []string{"", "28708", "2870820", "abcdef", "94287082"} {
+		if _, ok := Validate(secret, code, now); ok {
+			t.Errorf("принят код %q", code)
+		}
+	}
+	if _, ok := Validate("не base32", "287082", now); ok {
+		t.Error("принят код для неверного секрета")
+	}
+}
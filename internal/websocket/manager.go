@@ -9,24 +9,39 @@ import (
 	//"github.com/sirupsen/logrus"
 )
 
+const (
+	// Protocol — подпротокол, который сервер выбирает при подключении
+	Protocol = "ggchat"
+	// TokenProtocolPrefix — префикс подпротокола, в котором клиент передает токен
+	TokenProtocolPrefix = "access_token."
+)
+
 type Client struct {
-	Id     string
-	UserId int
-	ChatId string
-	Conn   *websocket.Conn
-	Send   chan []byte
+	Id          string
+	UserId      int
+	ChatId      string
+	ChatType    string
+	SessionId   string
+	TokenId     int // персональный токен доступа, по которому открыто соединение; 0 — не по токену
+	DisplayName string
+	AvatarUrl   *string
+	Conn        *websocket.Conn
+	Send        chan []byte
 }
 
 type Message struct {
-	Id        int            `json:"id,omitempty"`
-	Type      string         `json:"type"`
-	Content   string         `json:"content,omitempty"`
-	Keys      map[int]string `json:"keys,omitempty"`
-	ChatId    string         `json:"chat_id"`
-	UserId    int            `json:"user_id"`
-	Status    string         `json:"status,omitempty"`
-	Timestamp time.Time      `json:"timestamp,omitempty"`
-	MessageId int            `json:"message_id,omitempty"`
+	Id              int            `json:"id,omitempty"`
+	Type            string         `json:"type"`
+	Content         string         `json:"content,omitempty"`
+	Keys            map[int]string `json:"keys,omitempty"` // ключ к content для каждого участника; в каналах не передается
+	ChatId          string         `json:"chat_id"`
+	UserId          int            `json:"user_id"`
+	SenderName      string         `json:"sender_name,omitempty"`
+	SenderAvatarUrl *string        `json:"sender_avatar_url,omitempty"`
+	Status          string         `json:"status,omitempty"`
+	Timestamp       time.Time      `json:"timestamp,omitempty"`
+	MessageId       int            `json:"message_id,omitempty"`
+	Payload         any            `json:"payload,omitempty"`
 }
 
 type BroadcastMessage struct {
@@ -34,20 +49,42 @@ type BroadcastMessage struct {
 	Keys    map[int]string
 }
 
+// DirectMessage — системное событие для конкретных пользователей во всех
+// их соединениях, независимо от того, какой чат открыт
+type DirectMessage struct {
+	UserIds []int
+	Message Message
+}
+
+type ChatMember struct {
+	ChatId string
+	UserId int
+}
+
 type Manager struct {
-	Clients     map[*Client]bool
-	Broadcast   chan BroadcastMessage
-	Register    chan *Client
-	Undergister chan *Client
-	Mutex       sync.Mutex
+	Clients           map[*Client]bool
+	Broadcast         chan BroadcastMessage
+	Register          chan *Client
+	Undergister       chan *Client
+	DisconnectSession chan string
+	DisconnectToken   chan int
+	DisconnectUser    chan int
+	DisconnectMember  chan ChatMember
+	Direct            chan DirectMessage
+	Mutex             sync.Mutex
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		Clients:     make(map[*Client]bool),
-		Broadcast:   make(chan BroadcastMessage),
-		Register:    make(chan *Client),
-		Undergister: make(chan *Client),
+		Clients:           make(map[*Client]bool),
+		Broadcast:         make(chan BroadcastMessage),
+		Register:          make(chan *Client),
+		Undergister:       make(chan *Client),
+		DisconnectSession: make(chan string),
+		DisconnectToken:   make(chan int),
+		DisconnectUser:    make(chan int),
+		DisconnectMember:  make(chan ChatMember),
+		Direct:            make(chan DirectMessage),
 	}
 }
 
@@ -68,10 +105,83 @@ func (m *Manager) Run() {
 			}
 			m.Mutex.Unlock()
 
+		case sessionId := <-m.DisconnectSession:
+			m.Mutex.Lock()
+			for client := range m.Clients {
+				if client.SessionId == sessionId {
+					delete(m.Clients, client)
+					close(client.Send)
+					client.Conn.Close()
+				}
+			}
+			m.Mutex.Unlock()
+
+		case tokenId := <-m.DisconnectToken:
+			m.Mutex.Lock()
+			for client := range m.Clients {
+				if client.TokenId == tokenId {
+					delete(m.Clients, client)
+					close(client.Send)
+					client.Conn.Close()
+				}
+			}
+			m.Mutex.Unlock()
+
+		case userId := <-m.DisconnectUser:
+			m.Mutex.Lock()
+			for client := range m.Clients {
+				if client.UserId == userId {
+					delete(m.Clients, client)
+					close(client.Send)
+					client.Conn.Close()
+				}
+			}
+			m.Mutex.Unlock()
+
+		case member := <-m.DisconnectMember:
+			m.Mutex.Lock()
+			for client := range m.Clients {
+				if client.UserId == member.UserId && client.ChatId == member.ChatId {
+					delete(m.Clients, client)
+					close(client.Send)
+					client.Conn.Close()
+				}
+			}
+			m.Mutex.Unlock()
+
+		case direct := <-m.Direct:
+			data := m.MarshalMessage(direct.Message)
+
+			// Получателей может быть много (подписчики канала), поэтому
+			// ищем их по множеству, а не перебором списка
+			recipients := make(map[int]struct{}, len(direct.UserIds))
+			for _, userId := range direct.UserIds {
+				recipients[userId] = struct{}{}
+			}
+
+			m.Mutex.Lock()
+			for client := range m.Clients {
+				if _, ok := recipients[client.UserId]; !ok {
+					continue
+				}
+
+				select {
+				case client.Send <- data:
+				default:
+					close(client.Send)
+					delete(m.Clients, client)
+				}
+			}
+			m.Mutex.Unlock()
+
 		case broadcastMsg := <-m.Broadcast:
 			message := broadcastMsg.Message
 			keys := broadcastMsg.Keys
 
+			// Без ключей сообщение у всех получателей одинаковое с точностью
+			// до статуса, поэтому сериализуем его один раз на статус
+			encoded := make(map[string][]byte, 2)
+
 			m.Mutex.Lock()
 			for client := range m.Clients {
 				if client.ChatId == message.ChatId {
@@ -92,8 +202,16 @@ func (m *Manager) Run() {
 						clientMessage.Status = "delivered"
 					}
 
+					data, ok := encoded[clientMessage.Status]
+					if !ok {
+						data = m.MarshalMessage(clientMessage)
+						if keys == nil {
+							encoded[clientMessage.Status] = data
+						}
+					}
+
 					select {
-					case client.Send <- m.MarshalMessage(clientMessage):
+					case client.Send <- data:
 					default:
 						close(client.Send)
 						delete(m.Clients, client)
@@ -116,3 +234,30 @@ func (m *Manager) MarshalMessage(msg Message) []byte {
 func (m *Manager) SendMessage(message Message, keys map[int]string) {
 	m.Broadcast <- BroadcastMessage{Message: message, Keys: keys}
 }
+
+// CloseSession закрывает все WebSocket-соединения, открытые под указанной сессией
+func (m *Manager) CloseSession(sessionId string) {
+	m.DisconnectSession <- sessionId
+}
+
+// CloseToken закрывает все WebSocket-соединения, открытые по персональному токену доступа
+func (m *Manager) CloseToken(tokenId int) {
+	m.DisconnectToken <- tokenId
+}
+
+// CloseUser закрывает все WebSocket-соединения пользователя, включая открытые по токену доступа
+func (m *Manager) CloseUser(userId int) {
+	m.DisconnectUser <- userId
+}
+
+// SendToUsers отправляет системное событие (например, об изменении состава чата)
+// во все соединения указанных пользователей
+func (m *Manager) SendToUsers(userIds []int, message Message) {
+	m.Direct <- DirectMessage{UserIds: userIds, Message: message}
+}
+
+// CloseChatMember закрывает соединения пользователя с одним чатом,
+// например после исключения из него
+func (m *Manager) CloseChatMember(chatId string, userId int) {
+	m.DisconnectMember <- ChatMember{ChatId: chatId, UserId: userId}
+}
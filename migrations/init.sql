@@ -1,40 +1,156 @@
-CREATE TABLE users (
+-- Скрипт можно запускать повторно: на новой базе он создает схему целиком,
+-- на существующей — только недостающие таблицы, колонки и индексы.
+-- Колонки, добавленные в уже существующие таблицы, продублированы
+-- в ALTER TABLE ... ADD COLUMN IF NOT EXISTS после CREATE TABLE.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS users (
     id SERIAL4 NOT NULL PRIMARY KEY,
     username VARCHAR(255) DEFAULT NULL,
     password VARCHAR(255) DEFAULT NULL,
+    email VARCHAR(255) DEFAULT NULL UNIQUE,
+    email_verified_at TIMESTAMP DEFAULT NULL,
+    display_name VARCHAR(64) DEFAULT NULL,
+    avatar_path VARCHAR(255) DEFAULT NULL,
+    bio TEXT DEFAULT NULL,
+    timezone VARCHAR(64) DEFAULT NULL,
+    discoverable BOOLEAN NOT NULL DEFAULT true,
+    role VARCHAR(16) NOT NULL DEFAULT 'user',
+    disabled_at TIMESTAMP DEFAULT NULL,
     created_at VARCHAR(200) DEFAULT NULL,
-    public_key TEXT DEFAULT NULL
+    public_key TEXT DEFAULT NULL,
+    totp_secret VARCHAR(64) DEFAULT NULL,
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_counter INT8 DEFAULT NULL,
+    deleted_at TIMESTAMP DEFAULT NULL
 );
 
-CREATE TABLE chats (
+-- Двухфакторная аутентификация
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64) DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter INT8 DEFAULT NULL;
+
+-- Почта, профиль, поиск, удаление аккаунта и роли
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255) DEFAULT NULL UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(64) DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_path VARCHAR(255) DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS chats (
     uuid UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
     name VARCHAR(255) DEFAULT NULL,
-    created_at TIMESTAMP NOT NULL DEFAULT now()
+    type VARCHAR(16) NOT NULL DEFAULT 'direct',
+    direct_key VARCHAR(32) DEFAULT NULL UNIQUE,
+    description TEXT DEFAULT NULL,
+    avatar_path VARCHAR(255) DEFAULT NULL,
+    created_by INT4 DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
 );
 
-CREATE TABLE chat_numbers (
+-- Чаты, созданные до появления групп, — личные переписки двух пользователей,
+-- поэтому type по умолчанию 'direct'
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS type VARCHAR(16) NOT NULL DEFAULT 'direct';
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS direct_key VARCHAR(32) DEFAULT NULL UNIQUE;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS description TEXT DEFAULT NULL;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS avatar_path VARCHAR(255) DEFAULT NULL;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS created_by INT4 DEFAULT NULL;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS chat_numbers (
     chat_id UUID NOT NULL,
     user_id INT4 NOT NULL,
+    role VARCHAR(16) NOT NULL DEFAULT 'member',
+    archived BOOLEAN NOT NULL DEFAULT false,
+    muted_until TIMESTAMP DEFAULT NULL,
+    pinned_order INT4 DEFAULT NULL,
+    folder_id INT4 DEFAULT NULL,
+    last_read_message_id INT8 DEFAULT NULL,
+    cleared_up_to INT8 DEFAULT NULL,
     joined_at TIMESTAMP NOT NULL DEFAULT now(),
     PRIMARY KEY (chat_id, user_id)
 );
 
-CREATE TABLE message (
+-- Существующие участники получают роль member, joined_at уже был в исходной схеме
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member';
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP DEFAULT NULL;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS pinned_order INT4 DEFAULT NULL;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS folder_id INT4 DEFAULT NULL;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS last_read_message_id INT8 DEFAULT NULL;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS cleared_up_to INT8 DEFAULT NULL;
+ALTER TABLE chat_numbers ADD COLUMN IF NOT EXISTS joined_at TIMESTAMP NOT NULL DEFAULT now();
+
+-- Ключ личного чата для пар, у которых он еще не задан. Если у пары
+-- несколько чатов, ключ получает самый старый, остальные остаются без ключа
+UPDATE chats
+SET direct_key = pairs.direct_key
+FROM (
+    SELECT DISTINCT ON (k.direct_key) k.chat_id, k.direct_key
+    FROM (
+        SELECT cn.chat_id, MIN(cn.user_id)::text || ':' || MAX(cn.user_id)::text AS direct_key
+        FROM chat_numbers cn
+        JOIN chats c ON c.uuid = cn.chat_id
+        WHERE c.type = 'direct' AND c.direct_key IS NULL
+        GROUP BY cn.chat_id
+        HAVING COUNT(*) = 2
+    ) k
+    JOIN chats c ON c.uuid = k.chat_id
+    ORDER BY k.direct_key, c.created_at, c.uuid
+) pairs
+WHERE chats.uuid = pairs.chat_id
+  AND NOT EXISTS (SELECT 1 FROM chats d WHERE d.direct_key = pairs.direct_key);
+
+-- Отметка прочтения: последнее прочитанное или собственное сообщение участника
+UPDATE chat_numbers
+SET last_read_message_id = r.last_id
+FROM (
+    SELECT chat_id, user_id, MAX(id) AS last_id
+    FROM (
+        SELECT m.chat_id, s.user_id, m.id
+        FROM message m
+        JOIN message_status s ON s.message_id = m.id
+        WHERE s.status = 'read'
+        UNION ALL
+        SELECT m.chat_id, m.sender_id, m.id
+        FROM message m
+    ) seen
+    GROUP BY chat_id, user_id
+) r
+WHERE chat_numbers.chat_id = r.chat_id
+  AND chat_numbers.user_id = r.user_id
+  AND chat_numbers.last_read_message_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS chat_numbers_user_id_idx ON chat_numbers (user_id);
+
+CREATE TABLE IF NOT EXISTS message (
     id BIGSERIAL NOT NULL PRIMARY KEY,
     chat_id UUID NOT NULL,
     sender_id INT4 NOT NULL,
     content TEXT NOT NULL,
-    sent_at TIMESTAMP NOT NULL DEFAULT now()
+    sent_at TIMESTAMP NOT NULL DEFAULT now(),
+    edited_at TIMESTAMP DEFAULT NULL
 );
 
-CREATE TABLE message_keys (
+ALTER TABLE message ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS message_chat_id_idx ON message (chat_id, id);
+
+CREATE TABLE IF NOT EXISTS message_keys (
     id SERIAL4 NOT NULL PRIMARY KEY,
     message_id INT4 NOT NULL,
     user_id INT4 NOT NULL,
     encrypted_key TEXT DEFAULT NULL
 );
 
-CREATE TABLE message_status (
+CREATE TABLE IF NOT EXISTS message_status (
     message_id INT8 NOT NULL,
     user_id INT4 NOT NULL,
     status VARCHAR(255) DEFAULT NULL,
@@ -42,7 +158,7 @@ CREATE TABLE message_status (
     PRIMARY KEY (message_id, user_id)
 );
 
-CREATE TABLE ai_chats (
+CREATE TABLE IF NOT EXISTS ai_chats (
     id SERIAL4 NOT NULL PRIMARY KEY,
     user_id INT4 NOT NULL,
     title VARCHAR(255) NOT NULL,
@@ -52,10 +168,204 @@ CREATE TABLE ai_chats (
     finalfilename text
 );
 
-CREATE TABLE ai_messages (
+CREATE TABLE IF NOT EXISTS ai_messages (
     id SERIAL4 NOT NULL PRIMARY KEY,
     chat_id INT4 NOT NULL,
     content TEXT NOT NULL,
     sender_type VARCHAR(4) NOT NULL,
     sent_at TIMESTAMP DEFAULT now()
-);
\ No newline at end of file
+);
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INT4 NOT NULL,
+    refresh_token_hash VARCHAR(64) NOT NULL,
+    device_label VARCHAR(255) DEFAULT NULL,
+    user_agent TEXT DEFAULT NULL,
+    ip VARCHAR(64) DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_label VARCHAR(255) DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INT4 NOT NULL,
+    attempts INT4 NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    failures INT4 NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    blocked_until TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ DEFAULT NULL
+);
+
+-- Запросы сброса пароля считаются отдельно от login_attempts,
+-- чтобы они не влияли на блокировку входа
+CREATE TABLE IF NOT EXISTS password_reset_requests (
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    requests INT4 NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (scope, key)
+);
+
+DELETE FROM login_attempts WHERE scope IN ('reset_email', 'reset_ip');
+
+CREATE INDEX IF NOT EXISTS users_username_trgm_idx ON users USING gin (username gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS users_display_name_trgm_idx ON users USING gin (display_name gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INT4 NOT NULL,
+    blocked_id INT4 NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ DEFAULT NULL,
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_user_id_idx ON access_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS document_generations (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    ai_chat_id INT4 NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS document_generations_created_at_idx ON document_generations (created_at);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    binding_hash VARCHAR(64) NOT NULL,
+    link_user_id INT4 DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Незавершенные входы без привязки к браузеру не пройдут проверку binding_hash
+ALTER TABLE oidc_login_states ADD COLUMN IF NOT EXISTS binding_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMP DEFAULT NULL,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    ticket_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    session_id UUID DEFAULT NULL,
+    token_id INT4 DEFAULT NULL,
+    scopes TEXT[] DEFAULT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE ws_tickets ADD COLUMN IF NOT EXISTS token_id INT4 DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS chat_role_changes (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    chat_id UUID NOT NULL,
+    actor_id INT4 NOT NULL,
+    user_id INT4 NOT NULL,
+    old_role VARCHAR(16) DEFAULT NULL,
+    new_role VARCHAR(16) DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_role_changes_chat_id_idx ON chat_role_changes (chat_id, created_at);
+
+CREATE TABLE IF NOT EXISTS chat_invites (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    chat_id UUID NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by INT4 NOT NULL,
+    requires_approval BOOLEAN NOT NULL DEFAULT false,
+    max_uses INT4 DEFAULT NULL,
+    uses INT4 NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_invites_chat_id_idx ON chat_invites (chat_id);
+
+CREATE TABLE IF NOT EXISTS chat_join_requests (
+    chat_id UUID NOT NULL,
+    user_id INT4 NOT NULL,
+    invite_id INT4 NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    decided_by INT4 DEFAULT NULL,
+    decided_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_folders (
+    id SERIAL4 NOT NULL PRIMARY KEY,
+    user_id INT4 NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    position INT4 NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_folders_user_id_idx ON chat_folders (user_id);
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    message_id INT8 NOT NULL,
+    content TEXT NOT NULL,
+    encrypted_keys JSONB NOT NULL DEFAULT '{}',
+    replaced_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_message_id_idx ON message_edits (message_id, id);